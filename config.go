@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// ConfigFile defines which config JSON file to load
+const ConfigFile = "config.json"
+
+// Where a setting came from, from the highest precedence to the lowest.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceProfile = "profile"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// settingOverride lets a global flag or an environment variable override a
+// setting of the config file.
+type settingOverride struct {
+	Key  string // Name of the setting in the config file
+	Flag string
+	Env  string
+}
+
+var settingOverrides = []settingOverride{
+	{Key: "AppClientID", Flag: "client-id", Env: "SPOTIFY_CLIENT_ID"},
+	{Key: "AppClientSecret", Flag: "client-secret", Env: "SPOTIFY_CLIENT_SECRET"},
+	{Key: "RedirectPort", Flag: "redirect-port", Env: "SPOTIFY_REDIRECT_PORT"},
+}
+
+// settingDefaults are the values settings left empty stand for.
+var settingDefaults = map[string]string{
+	"AuthFlow":       spotify.AuthFlowClientSecret,
+	"TokenStorage":   spotify.TokenStorageFile,
+	"VolumeStep":     strconv.Itoa(spotify.DefaultVolumeStep),
+	"OutputFormat":   spotify.OutputText,
+	"SearchLimit":    strconv.Itoa(spotify.DefaultSearchLimit),
+	"RequestTimeout": spotify.DefaultRequestTimeout.String(),
+	"LoginTimeout":   spotify.DefaultLoginTimeout.String(),
+	"MaxRetries":     strconv.Itoa(spotify.DefaultRetryPolicy.MaxRetries),
+	"RetryMaxWait":   spotify.DefaultRetryPolicy.MaxWait.String(),
+}
+
+// secretSettings are masked when shown.
+var secretSettings = map[string]bool{
+	"AppClientSecret": true,
+}
+
+// overrideFlags are the global flags of settingOverrides.
+func overrideFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, o := range settingOverrides {
+		flags = append(flags, &cli.StringFlag{Name: o.Flag, Usage: fmt.Sprintf("Override '%s' of the config file. (default: $%s)", o.Key, o.Env)})
+	}
+	return flags
+}
+
+// configPath returns the config file chosen with --config, or the one in the
+// config directory.
+func configPath(c *cli.Context) string {
+	if path := c.String("config"); path != "" {
+		return path
+	}
+	return filepath.Join(utils.GetConfigDir(), ConfigFile)
+}
+
+// loadedConfig is the config in effect, with where each setting came from.
+type loadedConfig struct {
+	*spotify.ConfigT
+	Path    string            // The config file
+	Missing bool              // Whether the config file is missing or empty
+	Sources map[string]string // Source of each setting that is not a default
+}
+
+// loadConfig loads the config file and applies the overrides of the selected
+// profile, environment variables and flags, in increasing precedence.
+func loadConfig(c *cli.Context) (*loadedConfig, error) {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return nil, err
+	}
+	path := configPath(c)
+	cfg, missing, err := spotify.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &loadedConfig{ConfigT: cfg, Path: path, Missing: missing, Sources: map[string]string{}}
+
+	fields := reflect.ValueOf(cfg).Elem()
+	for _, key := range settingKeys() {
+		if !fields.FieldByName(key).IsZero() {
+			loaded.Sources[key] = sourceFile
+		}
+	}
+
+	profileOverrides, err := p.loadOverrides()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.applyOverrides(cfg); err != nil {
+		return nil, err
+	}
+	for key := range profileOverrides {
+		loaded.Sources[key] = sourceProfile
+	}
+
+	for _, o := range settingOverrides {
+		if value := os.Getenv(o.Env); value != "" {
+			fields.FieldByName(o.Key).SetString(value)
+			loaded.Sources[o.Key] = sourceEnv
+		}
+		if c.IsSet(o.Flag) {
+			fields.FieldByName(o.Key).SetString(c.String(o.Flag))
+			loaded.Sources[o.Key] = sourceFlag
+		}
+	}
+	return loaded, nil
+}
+
+// settingKeys returns the names of all settings, in config file order.
+func settingKeys() []string {
+	t := reflect.TypeOf(spotify.ConfigT{})
+	keys := make([]string, t.NumField())
+	for i := range keys {
+		keys[i] = t.Field(i).Name
+	}
+	return keys
+}
+
+// describeSource tells where the setting key of cfg came from.
+func (cfg *loadedConfig) describeSource(c *cli.Context, key string) string {
+	source := cfg.Sources[key]
+	switch source {
+	case "":
+		return sourceDefault
+	case sourceFile:
+		return "file " + cfg.Path
+	case sourceProfile:
+		p, _ := selectedProfile(c)
+		return "profile " + p.Name
+	}
+	for _, o := range settingOverrides {
+		if o.Key != key {
+			continue
+		}
+		if source == sourceFlag {
+			return "flag --" + o.Flag
+		}
+		return "env $" + o.Env
+	}
+	return source
+}
+
+// formatSetting formats the value of the setting key, or its default when
+// it is not set.
+func (cfg *loadedConfig) formatSetting(key string) string {
+	value := reflect.ValueOf(cfg.ConfigT).Elem().FieldByName(key)
+	if value.IsZero() {
+		return settingDefaults[key]
+	}
+	switch value.Kind() {
+	case reflect.Slice:
+		parts := make([]string, value.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(value.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	case reflect.Ptr:
+		return fmt.Sprint(value.Elem().Interface())
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// showSetting formats the value of the setting key for display, with
+// secrets masked.
+func (cfg *loadedConfig) showSetting(key string) string {
+	shown := cfg.formatSetting(key)
+	if shown != "" && secretSettings[key] {
+		return strings.Repeat("*", 8)
+	}
+	return shown
+}
+
+// checkSettings validates the settings in effect, telling where a bad one
+// came from.
+func (cfg *loadedConfig) checkSettings(c *cli.Context) error {
+	for _, key := range settingKeys() {
+		if err := cfg.ValidateSetting(key); err != nil {
+			return usageError("%v, set by %s.", err, cfg.describeSource(c, key))
+		}
+	}
+	return nil
+}
+
+func getConfig(c *cli.Context) (*spotify.ConfigT, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkSettings(c); err != nil {
+		return nil, err
+	}
+	// Without a config file, flags and environment variables may be enough.
+	if cfg.Validate() == nil {
+		return cfg.ConfigT, nil
+	}
+
+	// A missing or incomplete config, like the empty one created below, means
+	// spotify-cli was not set up yet. In a terminal, walk the user through it.
+	if utils.IsTerminal(os.Stdin) && utils.IsTerminal(os.Stdout) {
+		if err := runSetup(c); err != nil {
+			return nil, err
+		}
+		if cfg, err = loadConfig(c); err != nil {
+			return nil, err
+		}
+		return cfg.ConfigT, nil
+	}
+
+	if cfg.Missing {
+		// Make sure the config directory exists, create if not
+		_ = os.MkdirAll(filepath.Dir(cfg.Path), 0700)
+		if err := spotify.SaveConfig(new(spotify.ConfigT), cfg.Path); err != nil {
+			return nil, err
+		}
+		fmt.Printf("No config file was found, so one was created for you at `%s`.\n", cfg.Path)
+	} else {
+		fmt.Printf("The config file at `%s` is not complete yet: %v.\n", cfg.Path, cfg.Validate())
+	}
+	fmt.Printf("Edit the config file with your Spotify Application credentials or run `config init` in a terminal to be walked through it.\n")
+	return nil, cli.Exit("", 0)
+}
+
+// settingKey returns the setting named key, ignoring case.
+func settingKey(key string) (string, error) {
+	for _, k := range settingKeys() {
+		if strings.EqualFold(k, key) {
+			return k, nil
+		}
+	}
+	return "", usageError("Unknown setting '%s'. Use `config list` to see all settings.", key)
+}
+
+// parseSetting sets the setting key of cfg to value, parsed as the type of
+// the setting. Lists are separated by commas.
+func parseSetting(cfg *spotify.ConfigT, key string, value string) error {
+	field := reflect.ValueOf(cfg).Elem().FieldByName(key)
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case int, *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return usageError("%s must be a number, not '%s'.", key, value)
+		}
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.ValueOf(&n))
+		} else {
+			field.SetInt(int64(n))
+		}
+	case spotify.Duration:
+		var d spotify.Duration
+		if err := d.UnmarshalText([]byte(value)); err != nil {
+			return usageError("%s must be a duration like '30s' or '5m', not '%s'.", key, value)
+		}
+		field.Set(reflect.ValueOf(d))
+	default:
+		return fmt.Errorf("setting %s cannot be set from the command line", key)
+	}
+	if err := cfg.ValidateSetting(key); err != nil {
+		return usageError("%v.", err)
+	}
+	return nil
+}
+
+// updateConfig loads the config file or, for profiles other than the default
+// one, their overrides, lets update change them and saves them again. The
+// file is locked meanwhile, so that changes made by others are not lost.
+// Returns the config with the overrides of the profile applied.
+func updateConfig(c *cli.Context, update func(cfg *spotify.ConfigT, overrides map[string]interface{}) error) (*spotify.ConfigT, error) {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return nil, err
+	}
+	configPath := configPath(c)
+	// Make sure the config directory exists, create if not
+	_ = os.MkdirAll(filepath.Dir(configPath), 0700)
+
+	unlock, err := utils.LockFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cfg, _, err := spotify.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Name == DefaultProfile {
+		if err := update(cfg, nil); err != nil {
+			return nil, err
+		}
+		return cfg, spotify.SaveConfig(cfg, configPath)
+	}
+
+	// Profiles other than the default one only save what they override.
+	overrides, err := p.loadOverrides()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.applyOverrides(cfg); err != nil {
+		return nil, err
+	}
+	if err := update(cfg, overrides); err != nil {
+		return nil, err
+	}
+	if err := p.saveOverrides(overrides); err != nil {
+		return nil, err
+	}
+	// Overrides the update removed are still applied to cfg, start over.
+	if cfg, _, err = spotify.LoadConfig(configPath); err != nil {
+		return nil, err
+	}
+	return cfg, p.applyOverrides(cfg)
+}
+
+// warnIncomplete points out what is still missing once a setting was saved.
+func warnIncomplete(cfg *spotify.ConfigT) {
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "The config is not complete yet: %v.\n", err)
+	}
+}
+
+func handleConfigGet(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("Positional argument `key` not provided.")
+	}
+	key, err := settingKey(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	fmt.Println(cfg.formatSetting(key))
+	return nil
+}
+
+func handleConfigSet(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return usageError("Positional arguments `key` and `value` not provided.")
+	}
+	key, err := settingKey(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	value := c.Args().Get(1)
+
+	cfg, err := updateConfig(c, func(cfg *spotify.ConfigT, overrides map[string]interface{}) error {
+		if err := parseSetting(cfg, key, value); err != nil {
+			return err
+		}
+		if overrides != nil {
+			overrides[key] = reflect.ValueOf(cfg).Elem().FieldByName(key).Interface()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Set %s.\n", key)
+	warnIncomplete(cfg)
+	return nil
+}
+
+func handleConfigUnset(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("Positional argument `key` not provided.")
+	}
+	key, err := settingKey(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := updateConfig(c, func(cfg *spotify.ConfigT, overrides map[string]interface{}) error {
+		if overrides != nil {
+			delete(overrides, key)
+			return nil
+		}
+		field := reflect.ValueOf(cfg).Elem().FieldByName(key)
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if p, _ := selectedProfile(c); p.Name != DefaultProfile {
+		fmt.Printf("Unset %s, profile '%s' uses the shared config for it again.\n", key, p.Name)
+	} else {
+		fmt.Printf("Unset %s.\n", key)
+	}
+	warnIncomplete(cfg)
+	return nil
+}
+
+func handleConfigList(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, key := range settingKeys() {
+		if c.Bool("sources") {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, cfg.showSetting(key), cfg.describeSource(c, key))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", key, cfg.showSetting(key))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cli.Exit(err.Error(), exitFailure)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSettingKey(t *testing.T) {
+	if key, err := settingKey("redirectport"); err != nil || key != "RedirectPort" {
+		t.Errorf("settingKey returned %q, %v", key, err)
+	}
+	if _, err := settingKey("RedirectURI"); err == nil {
+		t.Errorf("Expected an unknown setting to be an error")
+	}
+}
+
+func TestParseSetting(t *testing.T) {
+	zero, three := 0, 3
+	tests := []struct {
+		key         string
+		value       string
+		expected    spotify.ConfigT
+		expectError bool
+	}{
+		{"RedirectPort", "5555", spotify.ConfigT{RedirectPort: "5555"}, false},
+		{"RedirectPort", "test123", spotify.ConfigT{}, true},
+		{"RedirectPort", "65536", spotify.ConfigT{}, true},
+		{"Scopes", "user-top-read, user-follow-read", spotify.ConfigT{Scopes: []string{"user-top-read", "user-follow-read"}}, false},
+		{"VolumeStep", "5", spotify.ConfigT{VolumeStep: 5}, false},
+		{"VolumeStep", "five", spotify.ConfigT{}, true},
+		{"VolumeStep", "200", spotify.ConfigT{}, true},
+		{"MaxRetries", "0", spotify.ConfigT{MaxRetries: &zero}, false},
+		{"MaxRetries", "3", spotify.ConfigT{MaxRetries: &three}, false},
+		{"RequestTimeout", "10s", spotify.ConfigT{RequestTimeout: spotify.Duration(10 * time.Second)}, false},
+		{"RequestTimeout", "10", spotify.ConfigT{}, true},
+		{"OutputFormat", "yaml", spotify.ConfigT{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			cfg := new(spotify.ConfigT)
+			err := parseSetting(cfg, tt.key, tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error but got none")
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(*cfg, tt.expected) {
+				t.Errorf("parseSetting returned %v and set %+v", err, *cfg)
+			}
+		})
+	}
+}
+
+func TestSettingPrecedence(t *testing.T) {
+	tests := []struct {
+		name           string
+		file           string
+		profile        string
+		env            string
+		flag           string
+		expected       string
+		expectedSource string
+	}{
+		{"Default", "", "", "", "", "", "default"},
+		{"File", "1111", "", "", "", "1111", "file"},
+		{"Profile over file", "1111", "2222", "", "", "2222", "profile work"},
+		{"Env over profile", "1111", "2222", "3333", "", "3333", "env $SPOTIFY_REDIRECT_PORT"},
+		{"Flag over env", "1111", "2222", "3333", "4444", "4444", "flag --redirect-port"},
+		{"Flag over file", "1111", "", "", "4444", "4444", "flag --redirect-port"},
+		{"Env without file", "", "", "3333", "", "3333", "env $SPOTIFY_REDIRECT_PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testHome(t)
+			path := filepath.Join(dir, ConfigFile)
+			if err := spotify.SaveConfig(&spotify.ConfigT{AppClientID: "client-id", RedirectPort: tt.file}, path); err != nil {
+				t.Fatal(err)
+			}
+			var args []string
+			if tt.profile != "" {
+				addProfile(t, "work", map[string]interface{}{"RedirectPort": tt.profile})
+				args = append(args, "--profile", "work")
+			}
+			setenv(t, "SPOTIFY_REDIRECT_PORT", tt.env)
+			if tt.flag != "" {
+				args = append(args, "--redirect-port", tt.flag)
+			}
+
+			c := testContext(t, args...)
+			cfg, err := loadConfig(c)
+			if err != nil {
+				t.Fatalf("loadConfig returned %v", err)
+			}
+			if cfg.RedirectPort != tt.expected {
+				t.Errorf("Expected RedirectPort %q but got %q", tt.expected, cfg.RedirectPort)
+			}
+			expectedSource := tt.expectedSource
+			if expectedSource == sourceFile {
+				expectedSource += " " + path
+			}
+			if source := cfg.describeSource(c, "RedirectPort"); source != expectedSource {
+				t.Errorf("Expected RedirectPort to come from %q but got %q", expectedSource, source)
+			}
+			if source := cfg.describeSource(c, "AppClientID"); source != "file "+path {
+				t.Errorf("Expected AppClientID to come from the file but got %q", source)
+			}
+		})
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	dir := testHome(t)
+	if err := spotify.SaveConfig(&spotify.ConfigT{AppClientID: "shared-id", RedirectPort: "5555"}, filepath.Join(dir, ConfigFile)); err != nil {
+		t.Fatal(err)
+	}
+	addProfile(t, "work", map[string]interface{}{"AppClientID": "work-id", "RedirectPort": "6666"})
+	c := testContext(t, "--profile", "work")
+
+	cfg, err := updateConfig(c, func(cfg *spotify.ConfigT, overrides map[string]interface{}) error {
+		delete(overrides, "RedirectPort")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateConfig returned %v", err)
+	}
+	if cfg.RedirectPort != "5555" || cfg.AppClientID != "work-id" {
+		t.Errorf("Expected the shared RedirectPort and the overridden AppClientID but got %+v", cfg)
+	}
+	overrides, err := newProfile("work").loadOverrides()
+	if _, ok := overrides["RedirectPort"]; err != nil || ok || overrides["AppClientID"] != "work-id" {
+		t.Errorf("Expected only the RedirectPort override to be removed but got %v, %v", overrides, err)
+	}
+}
+
+func TestCheckSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     spotify.ConfigT
+		profile  map[string]interface{}
+		env      string
+		args     []string
+		expected string // Where the bad setting came from, empty if none is bad
+	}{
+		{"Valid", spotify.ConfigT{VolumeStep: 20, OutputFormat: spotify.OutputJSON}, nil, "", nil, ""},
+		{"File", spotify.ConfigT{VolumeStep: 500}, nil, "", nil, "file"},
+		{"Profile", spotify.ConfigT{}, map[string]interface{}{"OutputFormat": "xml"}, "", nil, "profile work"},
+		{"Env", spotify.ConfigT{}, nil, "abc", nil, "env $SPOTIFY_REDIRECT_PORT"},
+		{"Flag", spotify.ConfigT{}, nil, "", []string{"--redirect-port", "0"}, "flag --redirect-port"},
+		{"Fixed by flag", spotify.ConfigT{RedirectPort: "abc"}, nil, "", []string{"--redirect-port", "5555"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testHome(t)
+			cfg := tt.file
+			cfg.AppClientID, cfg.AppClientSecret = "client-id", "client-secret"
+			if err := spotify.SaveConfig(&cfg, filepath.Join(dir, ConfigFile)); err != nil {
+				t.Fatal(err)
+			}
+			args := tt.args
+			if tt.profile != nil {
+				addProfile(t, "work", tt.profile)
+				args = append([]string{"--profile", "work"}, args...)
+			}
+			setenv(t, "SPOTIFY_REDIRECT_PORT", tt.env)
+
+			c := testContext(t, args...)
+			loaded, err := loadConfig(c)
+			if err != nil {
+				t.Fatalf("loadConfig returned %v", err)
+			}
+			err = loaded.checkSettings(c)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected the settings to be valid but got %v", err)
+				}
+				return
+			}
+			var exitErr cli.ExitCoder
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(err.Error(), "set by "+tt.expected) {
+				t.Errorf("Expected a usage error naming %s but got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	complete := spotify.ConfigT{AppClientID: "client-id", AppClientSecret: "client-secret", RedirectPort: "5555"}
+	tests := []struct {
+		name     string
+		file     *spotify.ConfigT // Nil if there is no config file
+		setUp    bool
+		expected spotify.ConfigT // What the config file is left with
+	}{
+		{"Missing", nil, false, spotify.ConfigT{}},
+		{"Empty", &spotify.ConfigT{}, false, spotify.ConfigT{}},
+		{"Incomplete", &spotify.ConfigT{AppClientID: "client-id", VolumeStep: 20}, false, spotify.ConfigT{AppClientID: "client-id", VolumeStep: 20}},
+		{"Complete", &complete, true, complete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testHome(t)
+			path := filepath.Join(dir, ConfigFile)
+			if tt.file != nil {
+				if err := spotify.SaveConfig(tt.file, path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Tests do not run in a terminal, so the setup is not started.
+			cfg, err := getConfig(testContext(t))
+			if tt.setUp {
+				if err != nil || !reflect.DeepEqual(*cfg, complete) {
+					t.Errorf("Expected the config to be used but got %+v, %v", cfg, err)
+				}
+			} else {
+				var exitErr cli.ExitCoder
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != 0 || cfg != nil {
+					t.Errorf("Expected to be told to set up spotify-cli but got %+v, %v", cfg, err)
+				}
+			}
+			if saved, _, err := spotify.LoadConfig(path); err != nil || !reflect.DeepEqual(*saved, tt.expected) {
+				t.Errorf("Expected the config file to hold %+v but got %+v, %v", tt.expected, saved, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/urfave/cli/v2"
+)
+
+// fadeInterval is the shortest time between two volume changes of a fade,
+// so that long fades do not run into the rate limits of Spotify.
+var fadeInterval = 500 * time.Millisecond
+
+// interruptible returns a context that is cancelled on Ctrl-C, or when the
+// process is asked to terminate. Call stop once done to stop listening.
+func interruptible() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// interrupted reports an aborted command.
+func interrupted(what string) error {
+	return cli.Exit(what+" was aborted.", exitInterrupted)
+}
+
+// fadeVolume changes the volume from one percentage to another over the
+// given time, in steps. Returns the volume it was left at, which falls short
+// of to if ctx is cancelled first.
+func fadeVolume(ctx context.Context, Spotify *spotify.Spotify, from int, to int, over time.Duration) (int, error) {
+	distance := to - from
+	if distance < 0 {
+		distance = -distance
+	}
+	steps := int(over / fadeInterval)
+	if steps > distance {
+		steps = distance
+	}
+	if steps < 1 {
+		steps = 1
+	}
+
+	current := from
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for i := 1; i <= steps; i++ {
+		timer.Reset(over / time.Duration(steps))
+		select {
+		case <-ctx.Done():
+			return current, ctx.Err()
+		case <-timer.C:
+		}
+		percent := from + (to-from)*i/steps
+		if err := Spotify.Volume(percent); err != nil {
+			return current, err
+		}
+		current = percent
+	}
+	return current, nil
+}
+
+// parseFadeArgs parses the arguments of `volume fade`, the target volume
+// and --over, in any order.
+func parseFadeArgs(args []string) (int, time.Duration, error) {
+	set := flag.NewFlagSet("volume fade", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	over := set.Duration("over", 0, "")
+	var positional []string
+	for {
+		if err := set.Parse(args); err != nil {
+			return 0, 0, err
+		}
+		if set.NArg() == 0 {
+			break
+		}
+		positional = append(positional, set.Arg(0))
+		args = set.Args()[1:]
+	}
+
+	if len(positional) != 1 {
+		return 0, 0, errors.New("exactly one target `volume-percent` must be provided")
+	}
+	change, err := parseVolumeChange(positional[0], 0)
+	if err != nil || change.relative {
+		return 0, 0, fmt.Errorf("'%s' is not a volume between 0 and 100", positional[0])
+	}
+	if *over <= 0 {
+		return 0, 0, errors.New("--over must be a positive duration like '30s'")
+	}
+	return change.percent, *over, nil
+}
+
+func handleVolumeFade(c *cli.Context) error {
+	to, over, err := parseFadeArgs(c.Args().Tail())
+	if err != nil {
+		return usageError("Usage: volume fade <volume-percent> --over <duration>: %v.", err)
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	device, err := Spotify.ActiveDevice()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	fmt.Printf("Fading the volume from %d%% to %d%% over %v. Press Ctrl-C to stop.\n", device.VolumePercent, to, over)
+	percent, err := fadeVolume(ctx, Spotify, device.VolumePercent, to, over)
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("Stopped at %d%%.\n", percent)
+		return interrupted("The fade")
+	}
+	if err != nil {
+		return err
+	}
+	return showVolume(Spotify)
+}
+
+// fadeOutAndPause fades the volume out, pauses and then restores the volume,
+// so that playback resumes at the volume it was paused at. If aborted, the
+// volume is restored without pausing.
+func fadeOutAndPause(Spotify *spotify.Spotify, over time.Duration) error {
+	device, err := Spotify.ActiveDevice()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	_, fadeErr := fadeVolume(ctx, Spotify, device.VolumePercent, 0, over)
+	if fadeErr == nil {
+		fadeErr = Spotify.Pause()
+	}
+	if err := Spotify.Volume(device.VolumePercent); err != nil && fadeErr == nil {
+		fadeErr = err
+	}
+	if errors.Is(fadeErr, context.Canceled) {
+		return interrupted("Pausing")
+	}
+	return fadeErr
+}
+
+// fadeIn is a fade in of playback about to be started. Call start before
+// playing and finish after. If ctx is cancelled, the volume is set to the
+// target right away.
+type fadeIn struct {
+	ctx     context.Context
+	Spotify *spotify.Spotify
+	over    time.Duration
+	device  spotify.Device // Device playback starts on
+	target  int            // Volume to fade in to, -1 until started
+}
+
+// newFadeIn returns a fade in over the given time, none if it is zero.
+func newFadeIn(ctx context.Context, Spotify *spotify.Spotify, over time.Duration) *fadeIn {
+	return &fadeIn{ctx: ctx, Spotify: Spotify, over: over, target: -1}
+}
+
+// start silences the device playback is about to start on, even if it is
+// not active yet, so that playback does not start out loud.
+func (f *fadeIn) start(device spotify.Device) error {
+	f.device, f.target = device, device.VolumePercent
+	return f.Spotify.VolumeOnDevice(device, 0)
+}
+
+// abort restores the volume silenced by start, when playback failed to start.
+func (f *fadeIn) abort() {
+	if f.target >= 0 {
+		_ = f.Spotify.VolumeOnDevice(f.device, f.target)
+	}
+}
+
+// finish fades in to the volume the device had before.
+func (f *fadeIn) finish() error {
+	_, err := fadeVolume(f.ctx, f.Spotify, 0, f.target, f.over)
+	if errors.Is(err, context.Canceled) {
+		// Playback goes on, at the volume it would have ended up at.
+		if err := f.Spotify.Volume(f.target); err != nil {
+			return err
+		}
+		return interrupted("The fade in")
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+	"github.com/urfave/cli/v2"
+)
+
+// newPlayingSpotify returns a Spotify authorized against srv, playing a track
+// on a speaker at 50% volume.
+func newPlayingSpotify(t *testing.T, srv *spotifytest.Server) *spotify.Spotify {
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	track := spotifytest.Item{Type: "track", Name: "Borderline", URI: "spotify:track:5hM5arv9KDbCHS0k9uqwjr", Artists: []string{"Tame Impala"}, Duration: 237 * time.Second}
+	srv.AddDevice(spotify.Device{ID: "7bc21f5e", Name: "Kitchen", Type: "Speaker", IsActive: true})
+	srv.AddItem(track)
+	srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{track}, VolumePercent: 50})
+	return s
+}
+
+// volumeRequests returns the volumes srv was asked to set, in order.
+func volumeRequests(srv *spotifytest.Server) []int {
+	var volumes []int
+	for _, r := range srv.Requests() {
+		u, err := url.Parse(strings.TrimPrefix(r, "PUT "))
+		if err != nil || !strings.HasSuffix(u.Path, "/me/player/volume") {
+			continue
+		}
+		percent, _ := strconv.Atoi(u.Query().Get("volume_percent"))
+		volumes = append(volumes, percent)
+	}
+	return volumes
+}
+
+func fastFades(t *testing.T) {
+	old := fadeInterval
+	fadeInterval = time.Millisecond
+	t.Cleanup(func() {
+		fadeInterval = old
+	})
+}
+
+func TestParseFadeArgs(t *testing.T) {
+	for _, args := range [][]string{{"20", "--over", "30s"}, {"--over", "30s", "20"}, {"--over=30s", "20%"}} {
+		to, over, err := parseFadeArgs(args)
+		if err != nil || to != 20 || over != 30*time.Second {
+			t.Errorf("parseFadeArgs(%q) returned %d, %v, %v", args, to, over, err)
+		}
+	}
+	for _, args := range [][]string{{}, {"20"}, {"20", "--over", "0s"}, {"+10", "--over", "5s"}, {"20", "30", "--over", "5s"}, {"20", "--over", "soon"}, {"20", "--for", "5s"}} {
+		if _, _, err := parseFadeArgs(args); err == nil {
+			t.Errorf("Expected %q to be invalid", args)
+		}
+	}
+}
+
+func TestFadeVolume(t *testing.T) {
+	fastFades(t)
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newPlayingSpotify(t, srv)
+
+	percent, err := fadeVolume(context.Background(), s, 50, 20, 10*time.Millisecond)
+	if err != nil || percent != 20 {
+		t.Fatalf("fadeVolume returned %d, %v", percent, err)
+	}
+	volumes := volumeRequests(srv)
+	if len(volumes) != 10 || volumes[len(volumes)-1] != 20 {
+		t.Errorf("Expected 10 steps down to 20%% but got %v", volumes)
+	}
+	for i := 1; i < len(volumes); i++ {
+		if volumes[i] >= volumes[i-1] {
+			t.Errorf("Expected the volume to only go down but got %v", volumes)
+			break
+		}
+	}
+
+	t.Run("Aborted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		percent, err := fadeVolume(ctx, s, 20, 80, time.Second)
+		if !errors.Is(err, context.Canceled) || percent != 20 {
+			t.Errorf("Expected to stop at 20%% but got %d, %v", percent, err)
+		}
+	})
+}
+
+func TestFadeOutAndPause(t *testing.T) {
+	fastFades(t)
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newPlayingSpotify(t, srv)
+
+	if err := fadeOutAndPause(s, 5*time.Millisecond); err != nil {
+		t.Fatalf("fadeOutAndPause returned %v", err)
+	}
+	if p := srv.Player(); p.IsPlaying || p.VolumePercent != 50 {
+		t.Errorf("Expected to be paused at 50%% but got %+v", p)
+	}
+	if volumes := volumeRequests(srv); len(volumes) < 2 || volumes[len(volumes)-2] != 0 {
+		t.Errorf("Expected a fade to silence before restoring the volume but got %v", volumes)
+	}
+}
+
+func TestFadeIn(t *testing.T) {
+	fastFades(t)
+
+	t.Run("Active device", func(t *testing.T) {
+		srv := spotifytest.NewServer()
+		t.Cleanup(srv.Close)
+		s := newPlayingSpotify(t, srv)
+
+		fade := newFadeIn(context.Background(), s, 5*time.Millisecond)
+		device, err := s.PlaybackDevice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fade.start(device); err != nil {
+			t.Fatalf("start returned %v", err)
+		}
+		if v := srv.Player().VolumePercent; v != 0 {
+			t.Errorf("Expected playback to start silent but got %d%%", v)
+		}
+		if err := fade.finish(); err != nil {
+			t.Fatalf("finish returned %v", err)
+		}
+		if v := srv.Player().VolumePercent; v != 50 {
+			t.Errorf("Expected to fade in to 50%% but got %d%%", v)
+		}
+	})
+
+	t.Run("Other device", func(t *testing.T) {
+		srv := spotifytest.NewServer()
+		t.Cleanup(srv.Close)
+		s := newPlayingSpotify(t, srv)
+		bedroom := spotify.Device{ID: "1a2b3c4d", Name: "Bedroom", Type: "Speaker", VolumePercent: 70}
+		srv.AddDevice(bedroom)
+
+		fade := newFadeIn(context.Background(), s, 5*time.Millisecond)
+		if err := fade.start(bedroom); err != nil {
+			t.Fatalf("start returned %v", err)
+		}
+		if err := s.PlayOnDevice(bedroom); err != nil {
+			t.Fatal(err)
+		}
+		if v := srv.Player().VolumePercent; v != 0 {
+			t.Errorf("Expected playback to move over silent but got %d%%", v)
+		}
+		if err := fade.finish(); err != nil {
+			t.Fatalf("finish returned %v", err)
+		}
+		if p := srv.Player(); p.DeviceID != bedroom.ID || p.VolumePercent != 70 {
+			t.Errorf("Expected to fade in to 70%% on the bedroom speaker but got %+v", p)
+		}
+
+		silenced, transferred := -1, -1
+		for i, r := range srv.Requests() {
+			switch {
+			case strings.HasPrefix(r, "PUT /v1/me/player/volume?volume_percent=0&device_id="+bedroom.ID):
+				silenced = i
+			case r == "PUT /v1/me/player/":
+				transferred = i
+			}
+		}
+		if silenced < 0 || transferred < 0 || silenced > transferred {
+			t.Errorf("Expected the bedroom speaker to be silenced before playing on it but got %v", srv.Requests())
+		}
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		srv := spotifytest.NewServer()
+		t.Cleanup(srv.Close)
+		s := newPlayingSpotify(t, srv)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		fade := newFadeIn(ctx, s, time.Hour)
+		device, err := s.PlaybackDevice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fade.start(device); err != nil {
+			t.Fatalf("start returned %v", err)
+		}
+		cancel()
+		var exitErr cli.ExitCoder
+		if err := fade.finish(); !errors.As(err, &exitErr) || exitErr.ExitCode() != exitInterrupted {
+			t.Errorf("Expected the fade in to be interrupted but got %v", err)
+		}
+		if v := srv.Player().VolumePercent; v != 50 {
+			t.Errorf("Expected the volume to be restored to 50%% but got %d%%", v)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+)
+
+// migrateProgFilesDir moves the files older versions kept in ~/.spotify-cli
+// to the config and state directories, if those are elsewhere. Files already
+// at their destination are never replaced, so it is safe to run every time
+// and only does anything once. The old directory is removed when emptied.
+func migrateProgFilesDir() error {
+	oldDir := utils.GetProgFilesDir()
+	if !utils.UsesXDG() {
+		return nil
+	}
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	moved, err := migrateDir(oldDir, utils.GetConfigDir(), utils.GetStateDir())
+	if err != nil {
+		return err
+	}
+	profiles, _ := ioutil.ReadDir(filepath.Join(oldDir, "profiles"))
+	for _, entry := range profiles {
+		if !entry.IsDir() {
+			continue
+		}
+		// Profiles exist by their config directory, even if it stays empty.
+		configDir := filepath.Join(utils.GetConfigDir(), "profiles", entry.Name())
+		if err := os.MkdirAll(configDir, 0700); err != nil {
+			return err
+		}
+		n, err := migrateDir(filepath.Join(oldDir, "profiles", entry.Name()), configDir, filepath.Join(utils.GetStateDir(), "profiles", entry.Name()))
+		if err != nil {
+			return err
+		}
+		moved += n
+		os.Remove(filepath.Join(oldDir, "profiles", entry.Name()))
+	}
+	os.Remove(filepath.Join(oldDir, "profiles"))
+	os.Remove(oldDir)
+
+	if moved > 0 {
+		fmt.Fprintf(os.Stderr, "Moved settings from %s to %s and tokens to %s.\n", oldDir, utils.GetConfigDir(), utils.GetStateDir())
+	}
+	return nil
+}
+
+// migrateDir moves config files from oldDir to configDir and tokens to
+// stateDir, deleting stale lock files. It returns how many files it moved.
+func migrateDir(oldDir, configDir, stateDir string) (int, error) {
+	entries, err := ioutil.ReadDir(oldDir)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		var dir string
+		switch {
+		case entry.IsDir():
+			continue
+		case strings.HasSuffix(name, ".lock"):
+			os.Remove(filepath.Join(oldDir, name))
+			continue
+		case name == ConfigFile || name == CurrentProfileFile:
+			dir = configDir
+		case strings.HasPrefix(name, ".tokens"):
+			dir = stateDir
+		default:
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			continue
+		}
+		if err := moveFile(filepath.Join(oldDir, name), filepath.Join(dir, name)); err != nil {
+			return moved, fmt.Errorf("could not move %s to %s: %w", name, dir, err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// moveFile moves a file, copying it when it cannot simply be renamed, i.e.
+// to another file system.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(to, data); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
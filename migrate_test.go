@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestMigrateProgFilesDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG directories are not used on " + runtime.GOOS)
+	}
+	home, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(home)
+	})
+	setenv(t, "HOME", home)
+	for _, env := range []string{"SPOTIFY_CLI_HOME", "XDG_CONFIG_HOME", "XDG_STATE_HOME"} {
+		setenv(t, env, "")
+	}
+
+	oldDir := filepath.Join(home, ".spotify-cli")
+	oldFiles := map[string]string{
+		"config.json":                 `{"AppClientID": "test123"}`,
+		"profile":                     "work\n",
+		".tokens":                     `{"UserRefreshToken": "default"}`,
+		".tokens.lock":                "",
+		"profiles/work/.tokens":       `{"UserRefreshToken": "work"}`,
+		"profiles/work/config.json":   `{"AppClientID": "work123"}`,
+		"profiles/empty/.tokens.lock": "",
+	}
+	for name, contents := range oldFiles {
+		path := filepath.Join(oldDir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Files at the destination are kept.
+	newConfig := filepath.Join(home, ".config", "spotify-cli", "config.json")
+	os.MkdirAll(filepath.Dir(newConfig), 0700)
+	if err := ioutil.WriteFile(newConfig, []byte(`{"AppClientID": "newer"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateProgFilesDir(); err != nil {
+		t.Fatalf("migrateProgFilesDir returned %v", err)
+	}
+
+	expected := map[string]string{
+		".config/spotify-cli/config.json":                `{"AppClientID": "newer"}`,
+		".config/spotify-cli/profile":                    "work\n",
+		".config/spotify-cli/profiles/work/config.json":  `{"AppClientID": "work123"}`,
+		".local/state/spotify-cli/.tokens":               `{"UserRefreshToken": "default"}`,
+		".local/state/spotify-cli/profiles/work/.tokens": `{"UserRefreshToken": "work"}`,
+		".spotify-cli/config.json":                       `{"AppClientID": "test123"}`,
+	}
+	for name, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(home, name))
+		if err != nil || string(data) != contents {
+			t.Errorf("Expected %s to contain %q but got %q, %v", name, contents, data, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(home, ".config", "spotify-cli", "profiles", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the empty profile to be kept but got %v", err)
+	}
+	// Only the config file that was not moved is left behind.
+	if entries, _ := ioutil.ReadDir(oldDir); len(entries) != 1 {
+		t.Errorf("Expected only config.json to be left in %s but got %d files", oldDir, len(entries))
+	}
+
+	if err := migrateProgFilesDir(); err != nil {
+		t.Errorf("Migrating again returned %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultProfile is used unless another profile is selected. It keeps its
+// tokens and config directly in the state and config directories, where they
+// were before profiles.
+const DefaultProfile = "default"
+
+// CurrentProfileFile remembers the profile selected with `profile use`.
+const CurrentProfileFile = "profile"
+
+// validProfileName restricts profile names to what is safe in a file name.
+var validProfileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// profile is a named Spotify account. Each profile has its own tokens and
+// may override any setting of the shared config file.
+type profile struct {
+	Name      string
+	ConfigDir string // Holds the config overrides of the profile
+	StateDir  string // Holds the tokens of the profile
+}
+
+// newProfile returns the profile with the given name, which may not exist yet.
+func newProfile(name string) profile {
+	if name == DefaultProfile {
+		return profile{Name: name, ConfigDir: utils.GetConfigDir(), StateDir: utils.GetStateDir()}
+	}
+	return profile{
+		Name:      name,
+		ConfigDir: filepath.Join(utils.GetConfigDir(), "profiles", name),
+		StateDir:  filepath.Join(utils.GetStateDir(), "profiles", name),
+	}
+}
+
+// TokenFile is where the tokens of the profile are cached.
+func (p profile) TokenFile() string {
+	return filepath.Join(p.StateDir, ".tokens")
+}
+
+// ConfigFile holds the config overrides of the profile. The default profile
+// has no overrides, its config file is the shared one.
+func (p profile) ConfigFile() string {
+	return filepath.Join(p.ConfigDir, ConfigFile)
+}
+
+// Exists reports whether the profile was added.
+func (p profile) Exists() bool {
+	if p.Name == DefaultProfile {
+		return true
+	}
+	info, err := os.Stat(p.ConfigDir)
+	return err == nil && info.IsDir()
+}
+
+// loadOverrides returns the config overrides of the profile, keyed by
+// setting. Settings the profile does not override are left out.
+func (p profile) loadOverrides() (map[string]interface{}, error) {
+	overrides := map[string]interface{}{}
+	if p.Name == DefaultProfile {
+		return overrides, nil
+	}
+	err := utils.LoadJSON(p.ConfigFile(), &overrides)
+	if errors.Is(err, utils.ErrFileNotFound) || errors.Is(err, utils.ErrFileEmpty) {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the config of profile '%s': %w", p.Name, err)
+	}
+	return overrides, nil
+}
+
+// saveOverrides replaces the config overrides of the profile.
+func (p profile) saveOverrides(overrides map[string]interface{}) error {
+	return utils.SaveJSON(p.ConfigFile(), overrides)
+}
+
+// applyOverrides overrides the settings of cfg the profile sets.
+func (p profile) applyOverrides(cfg interface{}) error {
+	overrides, err := p.loadOverrides()
+	if err != nil || len(overrides) == 0 {
+		return err
+	}
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("could not apply the config of profile '%s': %w", p.Name, err)
+	}
+	return nil
+}
+
+// currentProfile returns the name of the profile selected with `profile use`.
+func currentProfile() string {
+	data, err := ioutil.ReadFile(filepath.Join(utils.GetConfigDir(), CurrentProfileFile))
+	if name := strings.TrimSpace(string(data)); err == nil && name != "" {
+		return name
+	}
+	return DefaultProfile
+}
+
+// selectedProfile returns the profile chosen with --profile or
+// $SPOTIFY_CLI_PROFILE, falling back to the one selected with `profile use`.
+func selectedProfile(c *cli.Context) (profile, error) {
+	name := c.String("profile")
+	if name == "" {
+		name = currentProfile()
+	}
+	p := newProfile(name)
+	if !validProfileName.MatchString(name) || !p.Exists() {
+		return p, usageError("Profile '%s' does not exist. Use `profile add %s` to add it.", name, name)
+	}
+	return p, nil
+}
+
+// listProfiles returns the names of all profiles, the default one first.
+func listProfiles() ([]string, error) {
+	names := []string{DefaultProfile}
+	entries, err := ioutil.ReadDir(filepath.Join(utils.GetConfigDir(), "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var added []string
+	for _, entry := range entries {
+		if entry.IsDir() && validProfileName.MatchString(entry.Name()) && entry.Name() != DefaultProfile {
+			added = append(added, entry.Name())
+		}
+	}
+	sort.Strings(added)
+	return append(names, added...), nil
+}
+
+func handleProfileList(c *cli.Context) error {
+	names, err := listProfiles()
+	if err != nil {
+		return err
+	}
+	current := currentProfile()
+	for _, name := range names {
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+	return nil
+}
+
+func handleProfileAdd(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if !validProfileName.MatchString(name) {
+		return usageError("Positional argument `name` must only contain letters, digits, '-' and '_'.")
+	}
+	p := newProfile(name)
+	if p.Exists() {
+		return usageError("Profile '%s' already exists.", name)
+	}
+	for _, dir := range []string{p.ConfigDir, p.StateDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Added profile '%s'. Log in with `--profile %s auth login`.\n", name, name)
+	fmt.Printf("It uses the shared config, override settings with `--profile %s config`.\n", name)
+	return nil
+}
+
+func handleProfileRemove(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return usageError("Positional argument `name` not provided.")
+	}
+	if name == DefaultProfile {
+		return usageError("The default profile cannot be removed. Use `auth logout --forget` to log out of it.")
+	}
+	p := newProfile(name)
+	if !validProfileName.MatchString(name) || !p.Exists() {
+		return usageError("Profile '%s' does not exist.", name)
+	}
+	for _, dir := range []string{p.ConfigDir, p.StateDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if currentProfile() == name {
+		if err := useProfile(DefaultProfile); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to the default profile.\n")
+	}
+	fmt.Printf("Removed profile '%s'.\n", name)
+	return nil
+}
+
+func handleProfileUse(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return usageError("Positional argument `name` not provided.")
+	}
+	if !validProfileName.MatchString(name) || !newProfile(name).Exists() {
+		return usageError("Profile '%s' does not exist. Use `profile add %s` to add it.", name, name)
+	}
+	if err := useProfile(name); err != nil {
+		return err
+	}
+	fmt.Printf("Using profile '%s'.\n", name)
+	return nil
+}
+
+// useProfile makes name the profile used unless another one is selected.
+func useProfile(name string) error {
+	file := filepath.Join(utils.GetConfigDir(), CurrentProfileFile)
+	if name == DefaultProfile {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(file, []byte(name+"\n"))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/urfave/cli/v2"
+)
+
+// testHome points all spotify-cli directories at a new temporary one.
+func testHome(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	setenv(t, "SPOTIFY_CLI_HOME", dir)
+	setenv(t, "SPOTIFY_CLI_PROFILE", "")
+	for _, o := range settingOverrides {
+		setenv(t, o.Env, "")
+	}
+	return dir
+}
+
+// testContext parses args with the global flags that select the profile and
+// override settings.
+func testContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("spotify-cli", flag.ContinueOnError)
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "profile", EnvVars: []string{"SPOTIFY_CLI_PROFILE"}},
+		&cli.StringFlag{Name: "config", EnvVars: []string{"SPOTIFY_CLI_CONFIG"}},
+	}
+	for _, f := range append(flags, overrideFlags()...) {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+// addProfile adds the profile name and lets it override settings.
+func addProfile(t *testing.T, name string, overrides map[string]interface{}) profile {
+	if err := handleProfileAdd(testContext(t, name)); err != nil {
+		t.Fatalf("profile add %s returned %v", name, err)
+	}
+	p := newProfile(name)
+	if overrides != nil {
+		if err := p.saveOverrides(overrides); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestSelectedProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      string
+		used     string
+		expected string // Empty if the selection is an error
+	}{
+		{"Default", nil, "", "", DefaultProfile},
+		{"Used", nil, "", "work", "work"},
+		{"Environment", nil, "home", "work", "home"},
+		{"Flag", []string{"--profile", "work"}, "home", "", "work"},
+		{"Flag for default", []string{"--profile", DefaultProfile}, "home", "work", DefaultProfile},
+		{"Unknown flag", []string{"--profile", "nope"}, "", "", ""},
+		{"Unknown environment", nil, "nope", "work", ""},
+		{"Invalid name", []string{"--profile", "../work"}, "", "", ""},
+		{"Removed since used", nil, "", "gone", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			addProfile(t, "work", nil)
+			addProfile(t, "home", nil)
+			if tt.used != "" {
+				if err := os.MkdirAll(newProfile(tt.used).ConfigDir, 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := useProfile(tt.used); err != nil {
+					t.Fatal(err)
+				}
+				if tt.used == "gone" {
+					os.RemoveAll(newProfile(tt.used).ConfigDir)
+				}
+			}
+			setenv(t, "SPOTIFY_CLI_PROFILE", tt.env)
+
+			p, err := selectedProfile(testContext(t, tt.args...))
+			if tt.expected == "" {
+				var exitErr cli.ExitCoder
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+					t.Errorf("Expected a usage error but got %v, %v", p.Name, err)
+				}
+				return
+			}
+			if err != nil || p.Name != tt.expected {
+				t.Errorf("Expected profile %s but got %s, %v", tt.expected, p.Name, err)
+			}
+		})
+	}
+}
+
+func TestProfileOverrides(t *testing.T) {
+	shared := spotify.ConfigT{AppClientID: "shared-id", AppClientSecret: "shared-secret", RedirectPort: "5555", VolumeStep: 5}
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		expected  spotify.ConfigT
+		sources   map[string]string
+	}{
+		{"No overrides", nil, shared, map[string]string{"AppClientID": sourceFile, "VolumeStep": sourceFile}},
+		{
+			"Other account",
+			map[string]interface{}{"AppClientID": "work-id", "AppClientSecret": "work-secret"},
+			spotify.ConfigT{AppClientID: "work-id", AppClientSecret: "work-secret", RedirectPort: "5555", VolumeStep: 5},
+			map[string]string{"AppClientID": sourceProfile, "AppClientSecret": sourceProfile, "RedirectPort": sourceFile},
+		},
+		{
+			"New setting",
+			map[string]interface{}{"OutputFormat": spotify.OutputJSON},
+			spotify.ConfigT{AppClientID: "shared-id", AppClientSecret: "shared-secret", RedirectPort: "5555", VolumeStep: 5, OutputFormat: spotify.OutputJSON},
+			map[string]string{"OutputFormat": sourceProfile, "VolumeStep": sourceFile},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testHome(t)
+			cfg := shared
+			if err := spotify.SaveConfig(&cfg, filepath.Join(dir, ConfigFile)); err != nil {
+				t.Fatal(err)
+			}
+			addProfile(t, "work", tt.overrides)
+
+			loaded, err := loadConfig(testContext(t, "--profile", "work"))
+			if err != nil {
+				t.Fatalf("loadConfig returned %v", err)
+			}
+			for _, key := range settingKeys() {
+				if got, expected := loaded.formatSetting(key), (&loadedConfig{ConfigT: &tt.expected}).formatSetting(key); got != expected {
+					t.Errorf("Expected %s to be %q but got %q", key, expected, got)
+				}
+			}
+			for key, source := range tt.sources {
+				if loaded.Sources[key] != source {
+					t.Errorf("Expected %s to come from %s but got %q", key, source, loaded.Sources[key])
+				}
+			}
+
+			// The shared config is left as it was for the other profiles.
+			loaded, err = loadConfig(testContext(t))
+			if err != nil || loaded.AppClientID != shared.AppClientID || loaded.OutputFormat != "" {
+				t.Errorf("Expected the default profile to use the shared config but got %+v, %v", loaded, err)
+			}
+		})
+	}
+}
+
+func TestProfileIsolation(t *testing.T) {
+	testHome(t)
+	work := addProfile(t, "work", nil)
+	home := addProfile(t, "home", nil)
+	def := newProfile(DefaultProfile)
+
+	profiles := []profile{def, work, home}
+	for i, p := range profiles {
+		for _, other := range profiles[i+1:] {
+			if p.StateDir == other.StateDir || p.TokenFile() == other.TokenFile() || p.stateFile() == other.stateFile() {
+				t.Errorf("Expected profiles %s and %s to keep their tokens and state apart", p.Name, other.Name)
+			}
+		}
+	}
+	for _, p := range []profile{work, home} {
+		if info, err := os.Stat(p.StateDir); err != nil || !info.IsDir() {
+			t.Errorf("Expected profile add to create %s but got %v", p.StateDir, err)
+		}
+	}
+
+	if err := work.updateState(func(state *localState) { state.MutedVolume = 40 }); err != nil {
+		t.Fatal(err)
+	}
+	if volume := work.loadState().MutedVolume; volume != 40 {
+		t.Errorf("Expected profile work to remember volume 40 but got %d", volume)
+	}
+	for _, p := range []profile{def, home} {
+		if volume := p.loadState().MutedVolume; volume != 0 {
+			t.Errorf("Expected profile %s not to see the state of work but got volume %d", p.Name, volume)
+		}
+	}
+}
+
+func TestProfileRemove(t *testing.T) {
+	tests := []struct {
+		name        string
+		remove      string
+		used        string
+		expectError bool
+		expectUsed  string
+	}{
+		{"Inactive", "work", "home", false, "home"},
+		{"Active", "work", "work", false, DefaultProfile},
+		{"Default", DefaultProfile, "", true, DefaultProfile},
+		{"Unknown", "nope", "work", true, "work"},
+		{"Invalid name", "../work", "", true, DefaultProfile},
+		{"No name", "", "work", true, "work"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			work := addProfile(t, "work", map[string]interface{}{"AppClientID": "work-id"})
+			if err := work.updateState(func(state *localState) { state.MutedVolume = 40 }); err != nil {
+				t.Fatal(err)
+			}
+			addProfile(t, "home", nil)
+			if tt.used != "" {
+				if err := useProfile(tt.used); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := []string{tt.remove}
+			if tt.remove == "" {
+				args = nil
+			}
+			err := handleProfileRemove(testContext(t, args...))
+			if tt.expectError {
+				var exitErr cli.ExitCoder
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+					t.Errorf("Expected a usage error but got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("profile remove returned %v", err)
+			}
+			if used := currentProfile(); used != tt.expectUsed {
+				t.Errorf("Expected profile %s to be used but got %s", tt.expectUsed, used)
+			}
+
+			removed := !tt.expectError
+			if work.Exists() == removed {
+				t.Errorf("Expected profile work to exist: %v", !removed)
+			}
+			if _, err := os.Stat(work.stateFile()); os.IsNotExist(err) != removed {
+				t.Errorf("Expected the state of profile work to be removed: %v, but got %v", removed, err)
+			}
+			if !newProfile("home").Exists() {
+				t.Errorf("Expected profile home to be left alone")
+			}
+		})
+	}
+}
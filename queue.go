@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/urfave/cli/v2"
+)
+
+// queueURIs returns the URIs to queue for the flags of `queue add`. Albums
+// are expanded into their tracks, since only tracks and episodes can be
+// queued.
+func queueURIs(c *cli.Context, Spotify *spotify.Spotify) ([]spotify.SpotifyURI, error) {
+	var uri spotify.SpotifyURI
+	var err error
+	switch {
+	case c.String("track") != "":
+		uri, err = Spotify.SimpleSearch(c.String("track"), "track")
+	case c.String("album") != "":
+		uri, err = Spotify.SimpleSearch(c.String("album"), "album")
+	default:
+		uri = spotify.SpotifyURI(c.String("uri"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch uri.Type() {
+	case "track", "episode":
+		return []spotify.SpotifyURI{uri}, nil
+	case "album":
+		tracks, err := Spotify.AlbumTracks(uri)
+		if err != nil {
+			return nil, err
+		}
+		uris := make([]spotify.SpotifyURI, len(tracks))
+		for i, t := range tracks {
+			uris[i] = t.URI
+		}
+		return uris, nil
+	}
+	return nil, usageError("Only tracks, episodes and albums can be queued, not '%s'.", uri)
+}
+
+func handleQueueAdd(c *cli.Context) error {
+	if c.String("track") == "" && c.String("album") == "" && c.String("uri") == "" {
+		return usageError("One of --track, --album or --uri must be provided.")
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+
+	if err := Spotify.CheckScopes("AddToQueue"); err != nil {
+		return err
+	}
+	uris, err := queueURIs(c, Spotify)
+	if err != nil {
+		return err
+	}
+	// Tracks are queued in order, one request each.
+	for i, uri := range uris {
+		if err := Spotify.AddToQueue(uri); err != nil {
+			if i > 0 {
+				fmt.Printf("Added %d of %d tracks to the queue.\n", i, len(uris))
+				return partialError{err}
+			}
+			return err
+		}
+	}
+	if len(uris) == 1 {
+		fmt.Printf("Added to the queue.\n")
+	} else {
+		fmt.Printf("Added %d tracks to the queue.\n", len(uris))
+	}
+	return nil
+}
+
+func handleQueueList(c *cli.Context) error {
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	queue, err := Spotify.Queue()
+	if err != nil {
+		return err
+	}
+	if Spotify.Config.OutputFormat == spotify.OutputJSON {
+		return printJSON(queue)
+	}
+
+	if queue.CurrentlyPlaying.URI == "" {
+		fmt.Printf("Nothing is playing.\n")
+	} else {
+		fmt.Printf("Now playing: %s\n", describeTrack(queue.CurrentlyPlaying))
+	}
+	if len(queue.Queue) == 0 {
+		fmt.Printf("Nothing plays next.\n")
+		return nil
+	}
+	fmt.Printf("Up next:\n")
+	for i, track := range queue.Queue {
+		fmt.Printf("%3d. %s\n", i+1, describeTrack(track))
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// ScheduleFile holds the alarms started by `schedule run`.
+const ScheduleFile = "schedule.json"
+
+// missedAlarm is how late an alarm may still go off, i.e. after the computer
+// woke up from sleep. Later ones are skipped rather than going off at noon.
+const missedAlarm = 15 * time.Minute
+
+// alarm starts playback at set times.
+type alarm struct {
+	ID     int
+	When   string             // Time of day and days, i.e. '07:00 weekdays', see parseAlarmTime
+	What   string             `json:",omitempty"` // What plays, as given, i.e. 'playlist "morning"'
+	URI    spotify.SpotifyURI `json:",omitempty"` // Plays this, or resumes playback if empty
+	Device string             `json:",omitempty"` // Device to play on, any partial identifier, or the active one if empty
+	Volume *int               `json:",omitempty"` // Volume to play at, or the volume of the device if nil
+	Ramp   spotify.Duration   `json:",omitempty"` // Time to raise the volume from silence over
+}
+
+// alarmTime is a time of day on some days of the week.
+type alarmTime struct {
+	hour, minute int
+	days         [7]bool // Indexed by time.Weekday
+}
+
+// dayNames maps the names of days, or groups of them, to the days.
+var dayNames = func() map[string][]time.Weekday {
+	names := map[string][]time.Weekday{
+		"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+		"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		"weekends": {time.Saturday, time.Sunday},
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		names[name] = []time.Weekday{day}
+		names[name[:3]] = []time.Weekday{day}
+	}
+	return names
+}()
+
+// parseAlarmTime parses a time of day like '07:00' or '7:30', followed by
+// 'daily', 'weekdays', 'weekends' or days like 'mon,wed,fri'. Without days,
+// it is daily.
+func parseAlarmTime(s string) (alarmTime, error) {
+	var at alarmTime
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields) > 2 {
+		return at, fmt.Errorf("'%s' is not a time like '07:00 weekdays'", s)
+	}
+
+	clock := strings.SplitN(fields[0], ":", 2)
+	if len(clock) != 2 || len(clock[1]) != 2 {
+		return at, fmt.Errorf("'%s' is not a time of day like '07:00'", fields[0])
+	}
+	var err error
+	if at.hour, err = strconv.Atoi(clock[0]); err != nil || at.hour < 0 || at.hour > 23 {
+		return at, fmt.Errorf("'%s' is not a time of day like '07:00'", fields[0])
+	}
+	if at.minute, err = strconv.Atoi(clock[1]); err != nil || at.minute < 0 || at.minute > 59 {
+		return at, fmt.Errorf("'%s' is not a time of day like '07:00'", fields[0])
+	}
+
+	days := "daily"
+	if len(fields) == 2 {
+		days = fields[1]
+	}
+	for _, name := range strings.Split(days, ",") {
+		weekdays, ok := dayNames[name]
+		if !ok {
+			return at, fmt.Errorf("'%s' is not 'daily', 'weekdays', 'weekends' or days like 'mon,wed,fri'", days)
+		}
+		for _, day := range weekdays {
+			at.days[day] = true
+		}
+	}
+	return at, nil
+}
+
+// next returns the first time the alarm goes off after t.
+func (at alarmTime) next(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, at.hour, at.minute, 0, 0, t.Location())
+		if day.After(t) && at.days[day.Weekday()] {
+			return day
+		}
+	}
+	// Unreachable, parseAlarmTime always sets some days.
+	return time.Time{}
+}
+
+// describe summarizes what the alarm does, i.e. 'playlist "morning" on
+// kitchen at 30%, ramping up over 5m0s'.
+func (a alarm) describe() string {
+	var parts []string
+	if a.What != "" {
+		parts = append(parts, a.What)
+	} else {
+		parts = append(parts, "resume playback")
+	}
+	if a.Device != "" {
+		parts = append(parts, "on "+a.Device)
+	}
+	if a.Volume != nil {
+		parts = append(parts, fmt.Sprintf("at %d%%", *a.Volume))
+	}
+	description := strings.Join(parts, " ")
+	if a.Ramp > 0 {
+		description += fmt.Sprintf(", ramping up over %v", a.Ramp)
+	}
+	return description
+}
+
+// ring starts playback as the alarm says. When ramping up the volume, the
+// device is silenced before playback starts on it. If ramping up is
+// aborted, the volume is set to where it was headed.
+func (a alarm) ring(ctx context.Context, Spotify *spotify.Spotify) error {
+	var device spotify.Device
+	var err error
+	switch {
+	case a.Device != "":
+		device, err = Spotify.FindDevice(a.Device)
+	case a.Ramp > 0 || a.Volume != nil:
+		device, err = Spotify.PlaybackDevice()
+	}
+	if err != nil {
+		return err
+	}
+
+	target := device.VolumePercent
+	if a.Volume != nil {
+		target = *a.Volume
+	}
+	switch {
+	case a.Ramp > 0:
+		err = Spotify.VolumeOnDevice(device, 0)
+	case a.Volume != nil:
+		err = Spotify.VolumeOnDevice(device, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case a.URI != "":
+		err = Spotify.PlayURIAt(a.URI, spotify.PlayOptions{Device: device})
+	case a.Device != "":
+		err = Spotify.PlayOnDevice(device)
+	default:
+		err = Spotify.Play()
+	}
+	if err != nil {
+		if a.Ramp > 0 || a.Volume != nil {
+			_ = Spotify.VolumeOnDevice(device, device.VolumePercent)
+		}
+		return err
+	}
+
+	if a.Ramp == 0 {
+		return nil
+	}
+	if _, err := fadeVolume(ctx, Spotify, 0, target, time.Duration(a.Ramp)); err != nil {
+		if errors.Is(err, context.Canceled) {
+			_ = Spotify.Volume(target)
+		}
+		return err
+	}
+	return nil
+}
+
+// scheduleFile is where the alarms of the profile are kept.
+func (p profile) scheduleFile() string {
+	return filepath.Join(p.ConfigDir, ScheduleFile)
+}
+
+// loadSchedule returns the alarms of the profile, none if there is no
+// schedule yet.
+func (p profile) loadSchedule() ([]alarm, error) {
+	var alarms []alarm
+	err := utils.LoadJSON(p.scheduleFile(), &alarms)
+	if errors.Is(err, utils.ErrFileNotFound) || errors.Is(err, utils.ErrFileEmpty) {
+		return nil, nil
+	}
+	return alarms, err
+}
+
+// updateSchedule lets update change the alarms of the profile and saves them.
+func (p profile) updateSchedule(update func(alarms []alarm) ([]alarm, error)) error {
+	if err := os.MkdirAll(p.ConfigDir, 0700); err != nil {
+		return err
+	}
+	unlock, err := utils.LockFile(p.scheduleFile())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	alarms, err := p.loadSchedule()
+	if err != nil {
+		return err
+	}
+	if alarms, err = update(alarms); err != nil {
+		return err
+	}
+	return utils.SaveJSON(p.scheduleFile(), alarms)
+}
+
+// alarmContent returns what an alarm plays, from the flags of `schedule add`.
+// Searches are done right away, so that the alarm plays the same thing every
+// time.
+func alarmContent(c *cli.Context) (string, spotify.SpotifyURI, error) {
+	var given []string
+	for _, Type := range []string{"track", "album", "artist", "playlist", "uri"} {
+		if c.String(Type) != "" {
+			given = append(given, Type)
+		}
+	}
+	switch len(given) {
+	case 0:
+		return "", "", nil
+	case 1:
+	default:
+		return "", "", usageError("Only one of --track, --album, --artist, --playlist or --uri can be given, not --%s.", strings.Join(given, " and --"))
+	}
+
+	Type, q := given[0], c.String(given[0])
+	if Type == "uri" {
+		return q, spotify.SpotifyURI(q), nil
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return "", "", err
+	}
+	uri, err := Spotify.SimpleSearch(q, Type)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s %q", Type, q), uri, nil
+}
+
+func handleScheduleAdd(c *cli.Context) error {
+	if helpAsked(c) {
+		return cli.ShowCommandHelp(c, c.Command.Name)
+	}
+	parsed, err := flagsAnywhere(c)
+	if errors.Is(err, flag.ErrHelp) {
+		return cli.ShowCommandHelp(c, c.Command.Name)
+	}
+	if err != nil {
+		return usageError("Usage: schedule add <time> [--playlist <name>] [--device <device>] [--volume <percent>] [--ramp <duration>]: %v.", err)
+	}
+	c = parsed
+	if c.NArg() == 0 {
+		return usageError("A time like '07:00 weekdays' must be provided.")
+	}
+	a := alarm{When: strings.Join(c.Args().Slice(), " "), Device: c.String("device"), Ramp: spotify.Duration(c.Duration("ramp"))}
+	at, err := parseAlarmTime(a.When)
+	if err != nil {
+		return usageError("Usage: schedule add <time>: %v.", err)
+	}
+	if c.IsSet("volume") {
+		volume := c.Int("volume")
+		if volume < 0 || volume > 100 {
+			return usageError("--volume must be between 0 and 100, not %d.", volume)
+		}
+		a.Volume = &volume
+	}
+	if a.Ramp < 0 {
+		return usageError("--ramp must not be negative.")
+	}
+	if a.What, a.URI, err = alarmContent(c); err != nil {
+		return err
+	}
+
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	err = p.updateSchedule(func(alarms []alarm) ([]alarm, error) {
+		for _, other := range alarms {
+			if other.ID >= a.ID {
+				a.ID = other.ID + 1
+			}
+		}
+		if a.ID == 0 {
+			a.ID = 1
+		}
+		return append(alarms, a), nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added alarm %d: %s, %s. Next at %s.\n", a.ID, a.When, a.describe(), at.next(time.Now()).Format("Mon Jan 2 15:04"))
+	fmt.Printf("Alarms only go off while `schedule run` is running.\n")
+	return nil
+}
+
+func handleScheduleList(c *cli.Context) error {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	alarms, err := p.loadSchedule()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if cfg.OutputFormat == spotify.OutputJSON {
+		if alarms == nil {
+			alarms = []alarm{}
+		}
+		return printJSON(alarms)
+	}
+	if len(alarms) == 0 {
+		fmt.Printf("No alarms are scheduled, see `schedule add`.\n")
+		return nil
+	}
+
+	now := time.Now()
+	for _, a := range alarms {
+		next := "never"
+		if at, err := parseAlarmTime(a.When); err == nil {
+			next = at.next(now).Format("Mon Jan 2 15:04")
+		}
+		fmt.Printf("%d\t%s\t%s\t(next: %s)\n", a.ID, a.When, a.describe(), next)
+	}
+	return nil
+}
+
+func handleScheduleRemove(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return usageError("Exactly one alarm `id` must be provided, see `schedule list`.")
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return usageError("'%s' is not the number of an alarm, see `schedule list`.", c.Args().First())
+	}
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+
+	err = p.updateSchedule(func(alarms []alarm) ([]alarm, error) {
+		for i, a := range alarms {
+			if a.ID == id {
+				return append(alarms[:i], alarms[i+1:]...), nil
+			}
+		}
+		return nil, cli.Exit(fmt.Sprintf("There is no alarm %d, see `schedule list`.", id), exitFailure)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed alarm %d.\n", id)
+	return nil
+}
+
+// dueAlarms returns the alarms going off after last, up to and including
+// now, and the ones missed because they are more than missedAlarm late.
+func dueAlarms(alarms []alarm, last time.Time, now time.Time) (due []alarm, missed []alarm) {
+	for _, a := range alarms {
+		at, err := parseAlarmTime(a.When)
+		if err != nil {
+			continue
+		}
+		switch next := at.next(last); {
+		case next.After(now):
+		case now.Sub(next) > missedAlarm:
+			missed = append(missed, a)
+		default:
+			due = append(due, a)
+		}
+	}
+	return due, missed
+}
+
+// nextAlarm returns when the first of the alarms goes off after now, or
+// the zero time if there are none.
+func nextAlarm(alarms []alarm, now time.Time) time.Time {
+	var first time.Time
+	for _, a := range alarms {
+		at, err := parseAlarmTime(a.When)
+		if err != nil {
+			continue
+		}
+		if next := at.next(now); first.IsZero() || next.Before(first) {
+			first = next
+		}
+	}
+	return first
+}
+
+func handleScheduleRun(c *cli.Context) error {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	fmt.Printf("Waiting for alarms, changes to the schedule are picked up as they are made. Press Ctrl-C to stop.\n")
+	last := time.Now()
+	for {
+		// Reload every time, so that `schedule add` and `remove` apply
+		// without a restart.
+		alarms, err := p.loadSchedule()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		due, missed := dueAlarms(alarms, last, now)
+		for _, a := range missed {
+			fmt.Fprintf(os.Stderr, "Skipped alarm %d, it should have gone off over %v ago.\n", a.ID, missedAlarm)
+		}
+		for _, a := range due {
+			fmt.Printf("%s Alarm %d: %s.\n", now.Format("15:04"), a.ID, a.describe())
+			err := a.ring(ctx, Spotify)
+			if errors.Is(err, context.Canceled) {
+				return interrupted("The schedule")
+			}
+			if err != nil {
+				// Keep going, the next alarm may well work.
+				fmt.Fprintf(os.Stderr, "Alarm %d failed: %v\n", a.ID, err)
+			}
+		}
+		last = now
+
+		// Wake up at least every minute to pick up changes to the schedule.
+		next := time.Now().Add(time.Minute)
+		if first := nextAlarm(alarms, now); !first.IsZero() && first.Before(next) {
+			next = first
+		}
+		if err := wait(ctx, time.Until(next)); err != nil {
+			return interrupted("The schedule")
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+)
+
+func TestParseAlarmTime(t *testing.T) {
+	cases := []struct {
+		input        string
+		hour, minute int
+		days         []time.Weekday
+	}{
+		{"07:00", 7, 0, []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}},
+		{"7:30 weekdays", 7, 30, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		{"23:59 Weekends", 23, 59, []time.Weekday{time.Sunday, time.Saturday}},
+		{"06:15 mon,wed,friday", 6, 15, []time.Weekday{time.Monday, time.Wednesday, time.Friday}},
+		{"0:05 daily", 0, 5, []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}},
+	}
+	for _, tc := range cases {
+		at, err := parseAlarmTime(tc.input)
+		if err != nil {
+			t.Errorf("parseAlarmTime(%q) returned %v", tc.input, err)
+			continue
+		}
+		var days [7]bool
+		for _, day := range tc.days {
+			days[day] = true
+		}
+		if at.hour != tc.hour || at.minute != tc.minute || at.days != days {
+			t.Errorf("parseAlarmTime(%q) returned %+v", tc.input, at)
+		}
+	}
+
+	for _, input := range []string{"", "7", "7am", "24:00", "07:60", "07:5", "07:00 someday", "07:00 mon,", "07:00 on weekdays"} {
+		if _, err := parseAlarmTime(input); err == nil {
+			t.Errorf("Expected %q to be invalid", input)
+		}
+	}
+}
+
+func TestAlarmNext(t *testing.T) {
+	at, err := parseAlarmTime("07:00 weekdays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friday := time.Date(2026, time.October, 16, 8, 0, 0, 0, time.Local)
+	cases := []struct {
+		after    time.Time
+		expected time.Time
+	}{
+		{friday, time.Date(2026, time.October, 19, 7, 0, 0, 0, time.Local)},
+		{friday.Add(-90 * time.Minute), time.Date(2026, time.October, 16, 7, 0, 0, 0, time.Local)},
+		// An alarm going off at t is not next after t.
+		{friday.Add(-time.Hour), time.Date(2026, time.October, 19, 7, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range cases {
+		if next := at.next(tc.after); !next.Equal(tc.expected) {
+			t.Errorf("Expected the alarm after %v at %v but got %v", tc.after, tc.expected, next)
+		}
+	}
+}
+
+func TestDueAlarms(t *testing.T) {
+	alarms := []alarm{{ID: 1, When: "07:00"}, {ID: 2, When: "07:30"}}
+	seven := time.Date(2026, time.October, 16, 7, 0, 0, 0, time.Local)
+
+	due, missed := dueAlarms(alarms, seven.Add(-time.Minute), seven.Add(time.Second))
+	if len(due) != 1 || due[0].ID != 1 || len(missed) != 0 {
+		t.Errorf("Expected alarm 1 to be due but got %v and missed %v", due, missed)
+	}
+	due, missed = dueAlarms(alarms, seven.Add(time.Second), seven.Add(time.Minute))
+	if len(due) != 0 || len(missed) != 0 {
+		t.Errorf("Expected no alarms to be due but got %v and missed %v", due, missed)
+	}
+	// Waking up from sleep long after an alarm should have gone off.
+	due, missed = dueAlarms(alarms, seven.Add(-time.Hour), seven.Add(40*time.Minute))
+	if len(due) != 1 || due[0].ID != 2 || len(missed) != 1 || missed[0].ID != 1 {
+		t.Errorf("Expected alarm 1 to be missed and 2 to be due but got %v and missed %v", due, missed)
+	}
+
+	if next := nextAlarm(alarms, seven); !next.Equal(seven.Add(30 * time.Minute)) {
+		t.Errorf("Expected the next alarm at 07:30 but got %v", next)
+	}
+	if next := nextAlarm(nil, seven); !next.IsZero() {
+		t.Errorf("Expected no next alarm but got %v", next)
+	}
+}
+
+// playerCalls returns the requests srv got that change the volume or start
+// playback, in order.
+func playerCalls(srv *spotifytest.Server) []string {
+	var calls []string
+	for _, r := range srv.Requests() {
+		r = strings.TrimPrefix(r, "PUT /v1/me/player")
+		if strings.HasPrefix(r, "/volume") || strings.HasPrefix(r, "/play") || r == "/" {
+			calls = append(calls, r)
+		}
+	}
+	return calls
+}
+
+func TestAlarmRing(t *testing.T) {
+	fastFades(t)
+	thirty := 30
+	bedroom := spotify.Device{ID: "1a2b3c4d", Name: "Bedroom", Type: "Speaker", VolumePercent: 60}
+	morning := spotify.SpotifyURI("spotify:playlist:37i9dQZF1DX0XUsuxWHRQd")
+	ramp := spotify.Duration(10 * time.Millisecond)
+	tests := []struct {
+		name           string
+		alarm          alarm
+		expectedDevice string
+		expectedVolume int
+		expectedCalls  []string // Calls before ramping up the volume, if it is
+	}{
+		{
+			"Ramp on another device",
+			alarm{URI: morning, Device: "bedroom", Volume: &thirty, Ramp: ramp},
+			bedroom.ID, 30,
+			[]string{"/volume?volume_percent=0&device_id=1a2b3c4d", "/play?device_id=1a2b3c4d"},
+		},
+		{
+			"Ramp to the volume of another device",
+			alarm{URI: morning, Device: "bedroom", Ramp: ramp},
+			bedroom.ID, 60,
+			[]string{"/volume?volume_percent=0&device_id=1a2b3c4d", "/play?device_id=1a2b3c4d"},
+		},
+		{
+			"Ramp on the active device",
+			alarm{URI: morning, Ramp: ramp},
+			"7bc21f5e", 80,
+			[]string{"/volume?volume_percent=0&device_id=7bc21f5e", "/play?device_id=7bc21f5e"},
+		},
+		{
+			"Resume on another device",
+			alarm{Device: "bedroom", Ramp: ramp},
+			bedroom.ID, 60,
+			[]string{"/volume?volume_percent=0&device_id=1a2b3c4d", "/"},
+		},
+		{
+			"Volume without ramp",
+			alarm{URI: morning, Device: "bedroom", Volume: &thirty},
+			bedroom.ID, 30,
+			[]string{"/volume?volume_percent=30&device_id=1a2b3c4d", "/play?device_id=1a2b3c4d"},
+		},
+		{
+			"Just play",
+			alarm{URI: morning},
+			"7bc21f5e", 80,
+			[]string{"/play?device_id=7bc21f5e"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spotifytest.NewServer()
+			t.Cleanup(srv.Close)
+			s := newPlayingSpotify(t, srv)
+			srv.AddDevice(bedroom)
+			srv.AddItem(spotifytest.Item{Type: "playlist", Name: "Morning", URI: morning, Tracks: srv.Player().Tracks})
+			srv.SetPlayer(spotifytest.Player{DeviceID: "7bc21f5e", Tracks: srv.Player().Tracks, VolumePercent: 80})
+			n := len(playerCalls(srv))
+
+			if err := tt.alarm.ring(context.Background(), s); err != nil {
+				t.Fatalf("ring returned %v", err)
+			}
+			p := srv.Player()
+			if !p.IsPlaying || p.DeviceID != tt.expectedDevice || p.VolumePercent != tt.expectedVolume {
+				t.Errorf("Expected to play on %s at %d%% but got %+v", tt.expectedDevice, tt.expectedVolume, p)
+			}
+			if tt.alarm.URI != "" && p.Context != tt.alarm.URI {
+				t.Errorf("Expected %s to play but got %s", tt.alarm.URI, p.Context)
+			}
+
+			calls := playerCalls(srv)[n:]
+			if len(calls) < len(tt.expectedCalls) || !reflect.DeepEqual(calls[:len(tt.expectedCalls)], tt.expectedCalls) {
+				t.Fatalf("Expected the calls to start with %v but got %v", tt.expectedCalls, calls)
+			}
+			ramped := calls[len(tt.expectedCalls):]
+			if tt.alarm.Ramp == 0 && len(ramped) != 0 {
+				t.Errorf("Expected no more calls but got %v", ramped)
+			}
+			if tt.alarm.Ramp > 0 && (len(ramped) < 2 || ramped[len(ramped)-1] != "/volume?volume_percent="+strconv.Itoa(tt.expectedVolume)) {
+				t.Errorf("Expected the volume to ramp up to %d%% but got %v", tt.expectedVolume, ramped)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := profile{Name: DefaultProfile, ConfigDir: dir, StateDir: dir}
+
+	if alarms, err := p.loadSchedule(); err != nil || len(alarms) != 0 {
+		t.Errorf("Expected no alarms but got %v, %v", alarms, err)
+	}
+	volume := 30
+	added := alarm{ID: 1, When: "07:00 weekdays", What: `playlist "morning"`, URI: "spotify:playlist:37i9dQZF1DX0XUsuxWHRQd", Volume: &volume, Ramp: spotify.Duration(5 * time.Minute)}
+	err = p.updateSchedule(func(alarms []alarm) ([]alarm, error) {
+		return append(alarms, added), nil
+	})
+	if err != nil {
+		t.Fatalf("updateSchedule returned %v", err)
+	}
+	alarms, err := p.loadSchedule()
+	if err != nil || len(alarms) != 1 || alarms[0].describe() != added.describe() || alarms[0].When != added.When {
+		t.Errorf("Expected the alarm to be saved but got %v, %v", alarms, err)
+	}
+
+	if err := ioutil.WriteFile(p.scheduleFile(), []byte("["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.loadSchedule(); err == nil {
+		t.Errorf("Expected a broken schedule to be an error, alarms should not silently go missing")
+	}
+}
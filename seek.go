@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// maxPosition bounds positions, far beyond the end of any track or episode.
+const maxPosition = 100 * time.Hour
+
+// seekTarget is a position to seek to, as given to `seek`.
+type seekTarget struct {
+	offset   time.Duration // Position, or distance from the current one if relative
+	relative bool
+	percent  float64 // Of the track duration, if positive
+}
+
+// parseSeekTarget parses positions like '1:30', '90', '2m', '+15s', '-10'
+// and '50%'. Numbers without unit are seconds.
+func parseSeekTarget(arg string) (seekTarget, error) {
+	var target seekTarget
+	if strings.HasSuffix(arg, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+		if err != nil || !(percent >= 0 && percent <= 100) {
+			return target, fmt.Errorf("'%s' is not a percentage between 0%% and 100%%", arg)
+		}
+		// 0% seeks to the start, like an absolute position of zero.
+		target.percent = percent
+		return target, nil
+	}
+
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(arg, "+"):
+		target.relative, arg = true, arg[1:]
+	case strings.HasPrefix(arg, "-"):
+		target.relative, arg, sign = true, arg[1:], -1
+	}
+	offset, err := parseClockDuration(arg)
+	if err != nil {
+		return target, err
+	}
+	target.offset = sign * offset
+	return target, nil
+}
+
+// parseClockDuration parses '1:30' or '1:02:03' clock times, plain seconds
+// like '90' and durations like '2m30s', up to maxPosition.
+func parseClockDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("no position was given")
+	}
+	if strings.Contains(s, ":") {
+		var d time.Duration
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("'%s' is not a time like '1:30'", s)
+		}
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || (i > 0 && (n > 59 || len(part) != 2)) {
+				return 0, fmt.Errorf("'%s' is not a time like '1:30'", s)
+			}
+			if d = d*60 + time.Duration(n); d > maxPosition/time.Second {
+				return 0, fmt.Errorf("'%s' is longer than %v", s, maxPosition)
+			}
+		}
+		return d * time.Second, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		// Also rejects NaN, and Inf from overflowing numbers.
+		if !(seconds >= 0 && seconds <= maxPosition.Seconds()) {
+			return 0, fmt.Errorf("'%s' is not a number of seconds up to %v", s, maxPosition)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("'%s' is not a position like '1:30', '90' or '2m'", s)
+	}
+	if d > maxPosition {
+		return 0, fmt.Errorf("'%s' is longer than %v", s, maxPosition)
+	}
+	return d, nil
+}
+
+// position resolves the target within a track of the given duration,
+// playing at progress. It is clamped to the track, ending a second before
+// its end, since seeking to the very end skips to the next track.
+func (target seekTarget) position(progress time.Duration, duration time.Duration) time.Duration {
+	position := target.offset
+	switch {
+	case target.percent > 0:
+		position = time.Duration(float64(duration) * target.percent / 100)
+	case target.relative:
+		position = progress + target.offset
+	}
+	if position < 0 {
+		position = 0
+	}
+	if last := duration - time.Second; duration > 0 && position > last {
+		position = last
+		if position < 0 {
+			position = 0
+		}
+	}
+	return position
+}
+
+// formatPosition formats a position in a track as a clock time, i.e. '1:30'.
+func formatPosition(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func handleSeek(c *cli.Context) error {
+	if helpAsked(c) {
+		return cli.ShowCommandHelp(c, c.Command.Name)
+	}
+	arg := c.Args().Get(0)
+	if arg == "" {
+		return usageError("Positional argument `position` not provided.")
+	}
+	target, err := parseSeekTarget(arg)
+	if err != nil {
+		return usageError("Positional argument `position` is invalid: %v.", err)
+	}
+
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	state, err := Spotify.CurrentState()
+	if err != nil {
+		return err
+	}
+	if state.Track.URI == "" {
+		return cli.Exit("Nothing is playing to seek in.", exitFailure)
+	}
+
+	duration := state.Track.Duration()
+	position := target.position(state.Progress(), duration)
+	if err := Spotify.Seek(position); err != nil {
+		return err
+	}
+	fmt.Printf("Seeked to %s / %s.\n", formatPosition(position), formatPosition(duration))
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeekTarget(t *testing.T) {
+	const (
+		progress = 60 * time.Second
+		duration = 200 * time.Second
+	)
+	tests := []struct {
+		arg      string
+		expected time.Duration
+	}{
+		{"1:30", 90 * time.Second},
+		{"0:05", 5 * time.Second},
+		{"90", 90 * time.Second},
+		{"2m", 120 * time.Second},
+		{"+15s", 75 * time.Second},
+		{"+15", 75 * time.Second},
+		{"-10", 50 * time.Second},
+		{"-1:00", 0},
+		{"-2m", 0},
+		{"+5m", duration - time.Second},
+		{"10:00", duration - time.Second},
+		{"50%", 100 * time.Second},
+		{"0%", 0},
+		{"100%", duration - time.Second},
+		{"99.9%", duration - time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			target, err := parseSeekTarget(tt.arg)
+			if err != nil {
+				t.Fatalf("parseSeekTarget returned %v", err)
+			}
+			if position := target.position(progress, duration); position != tt.expected {
+				t.Errorf("Expected %v but got %v", tt.expected, position)
+			}
+		})
+	}
+
+	for _, arg := range []string{"", "+", "abc", "1:3", "1:60", "1:2:3:4", "150%", "-5%", "x%", "NaN%", "1m-5s",
+		"NaN", "Inf", "+Inf", "1e400", "1e300", "360001", "100:00:01", "9999999999999:00", "101h"} {
+		if _, err := parseSeekTarget(arg); err == nil {
+			t.Errorf("Expected '%s' to be invalid", arg)
+		}
+	}
+}
+
+func TestSeekTargetShortTrack(t *testing.T) {
+	target, err := parseSeekTarget("100%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position := target.position(0, 500*time.Millisecond); position != 0 {
+		t.Errorf("Expected a track shorter than a second to be seeked to its start but got %v", position)
+	}
+}
+
+func TestFormatPosition(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                       "0:00",
+		90 * time.Second:        "1:30",
+		3723 * time.Second:      "1:02:03",
+		1499 * time.Millisecond: "0:01",
+	} {
+		if s := formatPosition(d); s != expected {
+			t.Errorf("Expected %v to be formatted as %s but got %s", d, expected, s)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultRedirectPort is suggested by `config init` when no port is set.
+const DefaultRedirectPort = "5555"
+
+// setupPrompt asks the questions of `config init`.
+type setupPrompt struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks question until it gets a valid answer. Empty answers pick def,
+// when there is one. check may reject an answer, telling why.
+func (p *setupPrompt) ask(question string, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		line, err := p.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("setup was not finished: %w", err)
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if answer == "" {
+			fmt.Fprintf(p.out, "An answer is required.\n")
+			continue
+		}
+		if check != nil {
+			if err := check(answer); err != nil {
+				fmt.Fprintf(p.out, "%v.\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes or no question, yes being the default.
+func (p *setupPrompt) confirm(question string) (bool, error) {
+	answer, err := p.ask(question+" [Y/n]", "y", func(answer string) error {
+		switch strings.ToLower(answer) {
+		case "y", "yes", "n", "no":
+			return nil
+		}
+		return errors.New("please answer yes or no")
+	})
+	return strings.HasPrefix(strings.ToLower(answer), "y"), err
+}
+
+// credentials asks for the settings needed to log in, suggesting those of
+// current. Ports are checked to be free with checkPort.
+func (p *setupPrompt) credentials(current spotify.ConfigT, checkPort func(string) error) (spotify.ConfigT, error) {
+	cfg := current
+	var err error
+	if cfg.AppClientID, err = p.ask("Client ID of your Spotify app", current.AppClientID, nil); err != nil {
+		return cfg, err
+	}
+
+	flow := spotify.AuthFlowClientSecret
+	if current.UsesPKCE() {
+		flow = spotify.AuthFlowPKCE
+	}
+	fmt.Fprintf(p.out, "Authenticate with the Client Secret, or with PKCE if you would rather not keep the secret on this machine.\n")
+	flow, err = p.ask(fmt.Sprintf("Use '%s' or '%s'", spotify.AuthFlowClientSecret, spotify.AuthFlowPKCE), flow, func(answer string) error {
+		if answer != spotify.AuthFlowClientSecret && answer != spotify.AuthFlowPKCE {
+			return fmt.Errorf("answer '%s' or '%s', not '%s'", spotify.AuthFlowClientSecret, spotify.AuthFlowPKCE, answer)
+		}
+		return nil
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if flow == spotify.AuthFlowPKCE {
+		cfg.AuthFlow, cfg.AppClientSecret = spotify.AuthFlowPKCE, ""
+	} else {
+		cfg.AuthFlow = spotify.AuthFlowClientSecret
+		if cfg.AppClientSecret, err = p.ask("Client Secret of your Spotify app", current.AppClientSecret, nil); err != nil {
+			return cfg, err
+		}
+	}
+
+	port := current.RedirectPort
+	if port == "" {
+		port = DefaultRedirectPort
+	}
+	cfg.RedirectPort, err = p.ask("Port of the Redirect URI (http://localhost:PORT) of your Spotify app", port, func(answer string) error {
+		check := spotify.ConfigT{RedirectPort: answer}
+		if err := check.ValidateSetting("RedirectPort"); err != nil {
+			return err
+		}
+		return checkPort(answer)
+	})
+	return cfg, err
+}
+
+// portFree checks nothing listens on port yet, so that the redirect of the
+// login can be received on it.
+func portFree(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("port %s is in use, pick another one and add it to your Spotify app", port)
+	}
+	return listener.Close()
+}
+
+// rejectedCredentials reports whether err is Spotify refusing the client ID
+// or secret, rather than any other failure to get a token.
+func rejectedCredentials(err error) bool {
+	var apiErr *spotify.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnauthorized) && apiErr.Reason == "invalid_client"
+}
+
+// runSetup walks the user through setting up spotify-cli: it asks for the
+// credentials of their Spotify app, checks Spotify accepts them, saves them
+// and logs in.
+func runSetup(c *cli.Context) error {
+	p := &setupPrompt{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	loaded, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Let's set up spotify-cli. You need the Client ID and Client Secret of an app registered at\n")
+	fmt.Printf("https://developer.spotify.com/dashboard, see the README.md for how to register one.\n\n")
+	current := *loaded.ConfigT
+	var Spotify *spotify.Spotify
+	for {
+		cfg, err := p.credentials(current, portFree)
+		if err != nil {
+			return err
+		}
+		current = cfg
+
+		if Spotify, err = spotifyFor(c, &cfg, p.in); err != nil {
+			return err
+		}
+		err = Spotify.VerifyCredentials()
+		if rejectedCredentials(err) {
+			fmt.Printf("Spotify rejected the Client ID or Client Secret: %v. Please check them.\n\n", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	_, err = updateConfig(c, func(cfg *spotify.ConfigT, overrides map[string]interface{}) error {
+		cfg.AppClientID = current.AppClientID
+		cfg.AppClientSecret = current.AppClientSecret
+		cfg.RedirectPort = current.RedirectPort
+		cfg.AuthFlow = current.AuthFlow
+		if overrides != nil {
+			overrides["AppClientID"] = cfg.AppClientID
+			overrides["RedirectPort"] = cfg.RedirectPort
+			overrides["AuthFlow"] = cfg.AuthFlow
+			if !cfg.UsesPKCE() {
+				overrides["AppClientSecret"] = cfg.AppClientSecret
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if p, _ := selectedProfile(c); p.Name != DefaultProfile {
+		fmt.Printf("Saved the settings to profile '%s' in %s.\n", p.Name, p.ConfigFile())
+	} else {
+		fmt.Printf("Saved the config to %s.\n", loaded.Path)
+	}
+
+	login, err := p.confirm(fmt.Sprintf("Make sure http://localhost:%s is a Redirect URI of your app. Log in now?", current.RedirectPort))
+	if err != nil || !login {
+		return err
+	}
+	if err := Spotify.Login(); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in. You're ready to go.\n")
+	return nil
+}
+
+func handleConfigInit(c *cli.Context) error {
+	if !utils.IsTerminal(os.Stdin) {
+		return usageError("`config init` asks questions and must be run in a terminal. Use `config set` instead.")
+	}
+	return runSetup(c)
+}
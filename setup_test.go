@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+)
+
+func TestSetupCredentials(t *testing.T) {
+	busy := func(port string) error {
+		if port == "8080" {
+			return errors.New("port 8080 is in use")
+		}
+		return nil
+	}
+	tests := []struct {
+		name     string
+		current  spotify.ConfigT
+		input    string
+		expected spotify.ConfigT
+	}{
+		{
+			"Client secret",
+			spotify.ConfigT{},
+			"my-id\n\nmy-secret\n\n",
+			spotify.ConfigT{AppClientID: "my-id", AppClientSecret: "my-secret", RedirectPort: DefaultRedirectPort, AuthFlow: spotify.AuthFlowClientSecret},
+		},
+		{
+			"PKCE",
+			spotify.ConfigT{},
+			"my-id\npkce\n4444\n",
+			spotify.ConfigT{AppClientID: "my-id", RedirectPort: "4444", AuthFlow: spotify.AuthFlowPKCE},
+		},
+		{
+			"Asks again until answers are valid",
+			spotify.ConfigT{},
+			"\nmy-id\nimplicit\nsecret\nclient-secret\nmy-secret\nhttp\n70000\n8080\n4444\n",
+			spotify.ConfigT{AppClientID: "my-id", AppClientSecret: "my-secret", RedirectPort: "4444", AuthFlow: spotify.AuthFlowClientSecret},
+		},
+		{
+			"Suggests the current settings",
+			spotify.ConfigT{AppClientID: "old-id", AppClientSecret: "old-secret", RedirectPort: "4444", VolumeStep: 5},
+			"\n\n\n\n",
+			spotify.ConfigT{AppClientID: "old-id", AppClientSecret: "old-secret", RedirectPort: "4444", AuthFlow: spotify.AuthFlowClientSecret, VolumeStep: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &setupPrompt{in: bufio.NewReader(strings.NewReader(tt.input)), out: ioutil.Discard}
+			cfg, err := p.credentials(tt.current, busy)
+			if err != nil || !reflect.DeepEqual(cfg, tt.expected) {
+				t.Errorf("credentials returned %v and %+v", err, cfg)
+			}
+		})
+	}
+
+	t.Run("Input ends early", func(t *testing.T) {
+		p := &setupPrompt{in: bufio.NewReader(strings.NewReader("my-id\n")), out: ioutil.Discard}
+		if _, err := p.credentials(spotify.ConfigT{}, busy); err == nil {
+			t.Errorf("Expected an error but got none")
+		}
+	})
+}
+
+func TestRejectedCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"Invalid client", &spotify.APIError{StatusCode: http.StatusBadRequest, Reason: "invalid_client"}, true},
+		{"Unauthorized client", &spotify.APIError{StatusCode: http.StatusUnauthorized, Reason: "invalid_client"}, true},
+		{"Other reason", &spotify.APIError{StatusCode: http.StatusBadRequest, Reason: "invalid_request"}, false},
+		{"Rate limited", &spotify.APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{"Server error", &spotify.APIError{StatusCode: http.StatusServiceUnavailable, Reason: "invalid_client"}, false},
+		{"Not an API error", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rejected := rejectedCredentials(tt.err); rejected != tt.expected {
+				t.Errorf("Expected %v but got %v", tt.expected, rejected)
+			}
+		})
+	}
+}
+
+func TestVerifyWrongCredentials(t *testing.T) {
+	srv := spotifytest.NewServer()
+	defer srv.Close()
+	cfg := srv.Config()
+	cfg.AppClientSecret = "wrong-secret"
+
+	err := spotify.New(cfg, spotifytest.TestOptions(t, srv)).VerifyCredentials()
+	if !rejectedCredentials(err) {
+		t.Errorf("Expected the credentials to be rejected but got %v", err)
+	}
+}
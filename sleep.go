@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// sleepPollInterval is how often the playback state is checked for the end
+// of a track.
+var sleepPollInterval = 5 * time.Second
+
+// sleepTimer pauses playback after some time, or at the end of some tracks.
+type sleepTimer struct {
+	after  time.Duration // Time until playback is paused, if counting time
+	tracks int           // Number of tracks to pause after, counting the current one, if counting tracks
+	fade   time.Duration // Time to fade out over, ending when the timer fires
+}
+
+// parseSleepArgs parses the arguments of `sleep`, one of a duration like
+// '30m', --end-of-track or --after N, which may be followed by 'tracks'.
+func parseSleepArgs(args []string, endOfTrack bool, after int, fade time.Duration) (sleepTimer, error) {
+	timer := sleepTimer{fade: fade}
+	if fade < 0 {
+		return timer, errors.New("--fade must not be negative")
+	}
+	if after > 0 && len(args) == 1 && (args[0] == "tracks" || args[0] == "track") {
+		args = nil
+	}
+
+	modes := 0
+	if len(args) > 0 {
+		modes++
+	}
+	if endOfTrack {
+		modes++
+		timer.tracks = 1
+	}
+	if after != 0 {
+		modes++
+		timer.tracks = after
+	}
+	switch {
+	case modes != 1:
+		return timer, errors.New("pass exactly one of a duration, --end-of-track or --after")
+	case len(args) > 1:
+		return timer, errors.New("pass a single duration")
+	case after < 0:
+		return timer, errors.New("--after must be a positive number of tracks")
+	case len(args) == 1:
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d <= 0 {
+			return timer, fmt.Errorf("'%s' is not a duration like '30m' or '1h15m'", args[0])
+		}
+		timer.after = d
+	}
+	return timer, nil
+}
+
+// wait waits for d to pass, or for ctx to be cancelled.
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitForTracks polls the playback state until the last of the given number
+// of tracks, counting the current one, has lead or less left to play. A
+// track ends when another one starts, or when the same one starts over
+// after getting close to its end.
+func waitForTracks(ctx context.Context, Spotify *spotify.Spotify, tracks int, lead time.Duration) error {
+	state, err := Spotify.CurrentState()
+	if err != nil {
+		return err
+	}
+	if state.Track.URI == "" {
+		return cli.Exit("Nothing is playing.", exitFailure)
+	}
+
+	left := tracks
+	for {
+		next := sleepPollInterval
+		if left == 1 {
+			remaining := state.Track.Duration() - state.Progress()
+			if remaining <= lead {
+				return nil
+			}
+			if remaining-lead < next {
+				next = remaining - lead
+			}
+		}
+		if err := wait(ctx, next); err != nil {
+			return err
+		}
+
+		previous := state
+		if state, err = Spotify.CurrentState(); err != nil {
+			return err
+		}
+		nearEnd := previous.Track.Duration()-previous.Progress() <= 2*sleepPollInterval
+		if state.Track.URI != previous.Track.URI || (state.Progress() < previous.Progress() && nearEnd) {
+			if left--; left == 0 {
+				// The last track ended between two polls.
+				return nil
+			}
+		}
+	}
+}
+
+// run waits for the timer to fire, fades out and pauses.
+func (t sleepTimer) run(ctx context.Context, Spotify *spotify.Spotify) error {
+	fade := t.fade
+	if t.tracks > 0 {
+		if err := waitForTracks(ctx, Spotify, t.tracks, fade); err != nil {
+			return err
+		}
+	} else {
+		if fade > t.after {
+			fade = t.after
+		}
+		if err := wait(ctx, t.after-fade); err != nil {
+			return err
+		}
+	}
+
+	state, err := Spotify.CurrentState()
+	if err != nil {
+		return err
+	}
+	if !state.IsPlaying {
+		fmt.Printf("Playback is already paused.\n")
+		return nil
+	}
+	if fade > 0 {
+		return fadeOutAndPause(Spotify, fade)
+	}
+	return Spotify.Pause()
+}
+
+// describe says when the timer fires, starting now.
+func (t sleepTimer) describe(now time.Time) string {
+	switch {
+	case t.tracks == 1:
+		return "at the end of this track"
+	case t.tracks > 1:
+		return fmt.Sprintf("at the end of %d tracks, counting this one", t.tracks)
+	default:
+		return fmt.Sprintf("in %v, at %s", t.after, now.Add(t.after).Format("15:04"))
+	}
+}
+
+// sleepLock is locked by the running sleep timer of the profile, if any.
+func (p profile) sleepLock() string {
+	return filepath.Join(p.StateDir, "sleep")
+}
+
+func handleSleep(c *cli.Context) (err error) {
+	parsed, err := flagsAnywhere(c)
+	if errors.Is(err, flag.ErrHelp) {
+		return cli.ShowAppHelp(c)
+	}
+	if err != nil {
+		return usageError("Usage: sleep <duration> | --end-of-track | --after <n> tracks: %v.", err)
+	}
+	c = parsed
+	timer, err := parseSleepArgs(c.Args().Slice(), c.Bool("end-of-track"), c.Int("after"), c.Duration("fade"))
+	if err != nil {
+		return usageError("Usage: sleep <duration> | --end-of-track | --after <n> tracks: %v.", err)
+	}
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.StateDir, 0700); err != nil {
+		return err
+	}
+	unlock, ok, err := utils.TryLockFile(p.sleepLock())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return cli.Exit("A sleep timer is already running, see `sleep cancel`.", exitFailure)
+	}
+	defer unlock()
+
+	pid := os.Getpid()
+	if err := p.updateState(func(state *localState) { state.SleepPID, state.SleepCancelled = pid, false }); err != nil {
+		return err
+	}
+	defer func() {
+		clearErr := p.updateState(func(state *localState) {
+			if state.SleepPID == pid {
+				state.SleepPID, state.SleepCancelled = 0, false
+			}
+		})
+		if err == nil {
+			err = clearErr
+		}
+	}()
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	fmt.Printf("Pausing %s. Press Ctrl-C or run `sleep cancel` to cancel.\n", timer.describe(time.Now()))
+	return p.sleepStopped(timer.run(ctx, Spotify))
+}
+
+// sleepStopped returns err of the sleep timer of the profile, unless it was
+// stopped by `sleep cancel`: that is no error, only worth a notice.
+func (p profile) sleepStopped(err error) error {
+	var exitErr cli.ExitCoder
+	stopped := errors.Is(err, context.Canceled) || errors.As(err, &exitErr) && exitErr.ExitCode() == exitInterrupted
+	if !stopped {
+		return err
+	}
+	if p.loadState().SleepCancelled {
+		fmt.Printf("The sleep timer was cancelled.\n")
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return interrupted("The sleep timer")
+	}
+	return err
+}
+
+func handleSleepCancel(c *cli.Context) error {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.StateDir, 0700); err != nil {
+		return err
+	}
+	unlock, free, err := utils.TryLockFile(p.sleepLock())
+	if err != nil {
+		return err
+	}
+	if free {
+		// A timer that did not get to clean up left its process ID behind.
+		defer unlock()
+		fmt.Printf("No sleep timer is running.\n")
+		return p.updateState(func(state *localState) { state.SleepPID, state.SleepCancelled = 0, false })
+	}
+
+	pid := p.loadState().SleepPID
+	if pid == 0 {
+		return cli.Exit("The sleep timer is just starting, try again in a moment.", exitFailure)
+	}
+	if err := p.updateState(func(state *localState) { state.SleepCancelled = state.SleepPID == pid }); err != nil {
+		return err
+	}
+	proc, err := os.FindProcess(pid)
+	if err == nil {
+		err = proc.Signal(syscall.SIGTERM)
+		if err != nil && runtime.GOOS == "windows" {
+			// Windows has no signals, and so no chance to restore the volume.
+			err = proc.Kill()
+		}
+	}
+	if err != nil {
+		_ = p.updateState(func(state *localState) { state.SleepCancelled = false })
+		return fmt.Errorf("could not stop the sleep timer (process %d): %w", pid, err)
+	}
+	fmt.Printf("Cancelled the sleep timer.\n")
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+	"github.com/charlesyu108/spotify-cli/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseSleepArgs(t *testing.T) {
+	cases := []struct {
+		args       []string
+		endOfTrack bool
+		after      int
+		expected   sleepTimer
+	}{
+		{args: []string{"30m"}, expected: sleepTimer{after: 30 * time.Minute}},
+		{args: []string{"1h15m"}, expected: sleepTimer{after: 75 * time.Minute}},
+		{endOfTrack: true, expected: sleepTimer{tracks: 1}},
+		{after: 3, args: []string{"tracks"}, expected: sleepTimer{tracks: 3}},
+		{after: 2, expected: sleepTimer{tracks: 2}},
+	}
+	for _, tc := range cases {
+		timer, err := parseSleepArgs(tc.args, tc.endOfTrack, tc.after, 0)
+		if err != nil || timer != tc.expected {
+			t.Errorf("parseSleepArgs(%q, %v, %d) returned %+v, %v", tc.args, tc.endOfTrack, tc.after, timer, err)
+		}
+	}
+
+	invalid := []struct {
+		args       []string
+		endOfTrack bool
+		after      int
+	}{
+		{},
+		{args: []string{"30"}},
+		{args: []string{"-5m"}},
+		{args: []string{"30m", "--fade", "1m"}},
+		{args: []string{"30m"}, endOfTrack: true},
+		{endOfTrack: true, after: 2},
+		{after: -1},
+		{args: []string{"tracks"}, endOfTrack: true},
+	}
+	for _, tc := range invalid {
+		if _, err := parseSleepArgs(tc.args, tc.endOfTrack, tc.after, 0); err == nil {
+			t.Errorf("Expected %q, %v, %d to be invalid", tc.args, tc.endOfTrack, tc.after)
+		}
+	}
+	if _, err := parseSleepArgs([]string{"30m"}, false, 0, -time.Second); err == nil {
+		t.Errorf("Expected a negative fade to be invalid")
+	}
+}
+
+func fastSleeps(t *testing.T) {
+	old := sleepPollInterval
+	sleepPollInterval = time.Millisecond
+	t.Cleanup(func() {
+		sleepPollInterval = old
+	})
+}
+
+func TestWaitForTracks(t *testing.T) {
+	fastSleeps(t)
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newPlayingSpotify(t, srv)
+	first := srv.Player().Tracks[0]
+	second := spotifytest.Item{Type: "track", Name: "Let It Happen", URI: "spotify:track:2X485T9Z5Ly0xyaghN73ed", Artists: []string{"Tame Impala"}, Duration: 467 * time.Second}
+
+	t.Run("End of track", func(t *testing.T) {
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first}, Progress: first.Duration - time.Second})
+		if err := waitForTracks(context.Background(), s, 1, 2*time.Second); err != nil {
+			t.Errorf("waitForTracks returned %v", err)
+		}
+	})
+
+	t.Run("Next track", func(t *testing.T) {
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first, second}})
+		done := make(chan error, 1)
+		go func() {
+			done <- waitForTracks(context.Background(), s, 2, 0)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("Expected to wait for the second track but got %v", err)
+		default:
+		}
+
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first, second}, Index: 1, Progress: second.Duration})
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("waitForTracks returned %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected the end of the second track to be noticed")
+		}
+	})
+
+	t.Run("Repeated track", func(t *testing.T) {
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first}, Progress: first.Duration - time.Millisecond, Repeat: "track"})
+		done := make(chan error, 1)
+		go func() {
+			done <- waitForTracks(context.Background(), s, 2, 0)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first}, Repeat: "track"})
+		time.Sleep(20 * time.Millisecond)
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first}, Progress: first.Duration, Repeat: "track"})
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("waitForTracks returned %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected the track starting over to count as the end of it")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		srv.SetPlayer(spotifytest.Player{IsPlaying: true, DeviceID: "7bc21f5e", Tracks: []spotifytest.Item{first}})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := waitForTracks(ctx, s, 1, 0); err != context.Canceled {
+			t.Errorf("Expected waiting to be cancelled but got %v", err)
+		}
+	})
+}
+
+func TestSleepTimer(t *testing.T) {
+	fastFades(t)
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	s := newPlayingSpotify(t, srv)
+
+	timer := sleepTimer{after: 20 * time.Millisecond, fade: 10 * time.Millisecond}
+	if err := timer.run(context.Background(), s); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	if p := srv.Player(); p.IsPlaying || p.VolumePercent != 50 {
+		t.Errorf("Expected to be paused at 50%% but got %+v", p)
+	}
+	if volumes := volumeRequests(srv); len(volumes) < 2 || volumes[len(volumes)-2] != 0 {
+		t.Errorf("Expected a fade out before pausing but got %v", volumes)
+	}
+}
+
+func TestSleepLock(t *testing.T) {
+	testHome(t)
+	p := newProfile(DefaultProfile)
+	if err := os.MkdirAll(p.StateDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Nothing running", func(t *testing.T) {
+		// Left behind by a timer that was killed.
+		if err := p.updateState(func(state *localState) { state.SleepPID = 1 << 30 }); err != nil {
+			t.Fatal(err)
+		}
+		if err := handleSleepCancel(testContext(t)); err != nil {
+			t.Errorf("sleep cancel returned %v", err)
+		}
+		if pid := p.loadState().SleepPID; pid != 0 {
+			t.Errorf("Expected the stale process ID to be cleared but got %d", pid)
+		}
+	})
+
+	unlock, ok, err := utils.TryLockFile(p.sleepLock())
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile returned %v, %v", ok, err)
+	}
+	defer unlock()
+
+	t.Run("Already running", func(t *testing.T) {
+		if err := handleSleep(testContext(t, "30m")); err == nil {
+			t.Errorf("Expected a second sleep timer to be refused")
+		}
+	})
+
+	t.Run("Just starting", func(t *testing.T) {
+		if err := handleSleepCancel(testContext(t)); err == nil {
+			t.Errorf("Expected cancelling a timer without a process ID yet to be an error")
+		}
+	})
+
+	t.Run("Running", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("No sleep command on " + runtime.GOOS)
+		}
+		cmd := exec.Command("sleep", "60")
+		if err := cmd.Start(); err != nil {
+			t.Skipf("Could not start a stand-in timer: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		if err := p.updateState(func(state *localState) { state.SleepPID = cmd.Process.Pid }); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := handleSleepCancel(testContext(t)); err != nil {
+			t.Errorf("sleep cancel returned %v", err)
+		}
+		if !p.loadState().SleepCancelled {
+			t.Errorf("Expected the timer to be told it was cancelled")
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			t.Errorf("Expected the timer to be stopped")
+		}
+	})
+}
+
+func TestSleepStopped(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		cancelled    bool
+		expectedCode int // -1 if no error is expected
+	}{
+		{"Cancelled while waiting", context.Canceled, true, -1},
+		{"Cancelled while fading", interrupted("Pausing"), true, -1},
+		{"Ctrl-C while waiting", context.Canceled, false, exitInterrupted},
+		{"Ctrl-C while fading", interrupted("Pausing"), false, exitInterrupted},
+		{"Failed", cli.Exit("Nothing is playing.", exitFailure), true, exitFailure},
+		{"Paused", nil, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t)
+			p := newProfile(DefaultProfile)
+			if err := p.updateState(func(state *localState) { state.SleepPID, state.SleepCancelled = os.Getpid(), tt.cancelled }); err != nil {
+				t.Fatal(err)
+			}
+
+			err := p.sleepStopped(tt.err)
+			if tt.expectedCode < 0 {
+				if err != nil {
+					t.Errorf("Expected no error but got %v", err)
+				}
+				return
+			}
+			var exitErr cli.ExitCoder
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != tt.expectedCode {
+				t.Errorf("Expected exit code %d but got %v", tt.expectedCode, err)
+			}
+		})
+	}
+}
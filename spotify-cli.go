@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -14,15 +20,27 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-// ConfigFile defines which config JSON file to load
-const ConfigFile = "config.json"
-
 func main() {
 
 	app := &cli.App{
 		Name:                 "spotify-cli",
 		Usage:                "Use Spotify from the Command Line.",
 		EnableBashCompletion: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "api-url", Usage: "Base URL of the Spotify Web API.", EnvVars: []string{"SPOTIFY_API_URL"}, Value: spotify.DefaultAPIURL},
+			&cli.StringFlag{Name: "accounts-url", Usage: "Base URL of the Spotify Accounts service.", EnvVars: []string{"SPOTIFY_ACCOUNTS_URL"}, Value: spotify.DefaultAccountsURL},
+			&cli.StringFlag{Name: "proxy", Usage: "Route all requests through this proxy URL. (default: $HTTPS_PROXY)", EnvVars: []string{"SPOTIFY_CLI_PROXY"}},
+			&cli.DurationFlag{Name: "timeout", Usage: "Timeout for each request to Spotify. (default: 'RequestTimeout' of the config file, or 30s)"},
+			&cli.StringFlag{Name: "profile", Aliases: []string{"p"}, Usage: "Use the tokens and config of this profile. (default: the one chosen with 'profile use')", EnvVars: []string{"SPOTIFY_CLI_PROFILE"}},
+			&cli.StringFlag{Name: "config", Usage: "Load settings from this config file. (default: config.json in the config directory)", EnvVars: []string{"SPOTIFY_CLI_CONFIG"}},
+		},
+	}
+	app.Flags = append(app.Flags, overrideFlags()...)
+	app.Before = func(c *cli.Context) error {
+		if err := migrateProgFilesDir(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		return nil
 	}
 
 	app.Commands = []*cli.Command{
@@ -34,12 +52,16 @@ func main() {
 			Action:   handlePlay,
 			Aliases:  []string{"pl"},
 			Flags: []cli.Flag{
-				&cli.StringFlag{Name: "device", Aliases: []string{"d"}, Usage: "Play music on a device. Use any partial identifier i.e. 'mbp', '064a', 'smartphone', etc."},
+				&cli.StringFlag{Name: "device", Aliases: []string{"d"}, Usage: "Play music on a device, moving the playback there if nothing else is given to play. Use any partial identifier i.e. 'mbp', '064a', 'smartphone', etc."},
 				&cli.StringFlag{Name: "track", Aliases: []string{"t"}, Usage: "A track to play."},
 				&cli.StringFlag{Name: "album", Aliases: []string{"m"}, Usage: "An album to play."},
 				&cli.StringFlag{Name: "artist", Aliases: []string{"r"}, Usage: "An artist to play."},
 				&cli.StringFlag{Name: "uri", Aliases: []string{"u"}, Usage: "Anything, by using spotify uri.(format: spotify:<type>:<id>)"},
 				&cli.StringFlag{Name: "playlist", Aliases: []string{"l"}, Usage: "An playlist to play."},
+				&cli.DurationFlag{Name: "fade-in", Usage: "Start silent and raise the volume to where it was over this time, i.e. '5s'."},
+				&cli.IntFlag{Name: "offset", Usage: "Number of tracks of the album or playlist to skip, i.e. '4' starts at the fifth."},
+				&cli.StringFlag{Name: "from-track", Usage: "Start the album or playlist at the first track whose name contains this."},
+				&cli.StringFlag{Name: "position", Usage: "Start this far into the first track, i.e. '1:20', '80' or '1m20s'."},
 			},
 		},
 		{
@@ -48,6 +70,69 @@ func main() {
 			Usage:    "Pause playback.",
 			Aliases:  []string{"ps"},
 			Action:   handlePause,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{Name: "fade", Usage: "Lower the volume to silence over this time before pausing, i.e. '5s'. The volume is restored once paused."},
+			},
+		},
+		{
+			Name:     "schedule",
+			Category: "Playback",
+			Usage:    "Start playback at set times, like an alarm clock. Alarms go off while 'schedule run' is running.",
+			Subcommands: []*cli.Command{
+				{
+					Name:            "add",
+					Usage:           "Add an alarm at a time of day, i.e. '07:00', optionally followed by 'daily', 'weekdays', 'weekends' or days like 'mon,wed,fri'.",
+					ArgsUsage:       "<time> [days]",
+					Action:          handleScheduleAdd,
+					SkipFlagParsing: true,
+					Flags: []cli.Flag{
+						&cli.StringFlag{Name: "track", Aliases: []string{"t"}, Usage: "A track to play."},
+						&cli.StringFlag{Name: "album", Aliases: []string{"m"}, Usage: "An album to play."},
+						&cli.StringFlag{Name: "artist", Aliases: []string{"r"}, Usage: "An artist to play."},
+						&cli.StringFlag{Name: "playlist", Aliases: []string{"l"}, Usage: "A playlist to play."},
+						&cli.StringFlag{Name: "uri", Aliases: []string{"u"}, Usage: "Play by using spotify uri.(format: spotify:<type>:<id>)"},
+						&cli.StringFlag{Name: "device", Aliases: []string{"d"}, Usage: "Play on this device, any partial identifier. (default: the active device)"},
+						&cli.IntFlag{Name: "volume", Usage: "Play at this volume percent. (default: the volume of the device)"},
+						&cli.DurationFlag{Name: "ramp", Usage: "Raise the volume from silence over this time, i.e. '5m'."},
+					},
+				},
+				{
+					Name:   "list",
+					Usage:  "Show the alarms and when they go off next.",
+					Action: handleScheduleList,
+				},
+				{
+					Name:      "remove",
+					Aliases:   []string{"rm"},
+					Usage:     "Remove an alarm by the number shown by 'schedule list'.",
+					ArgsUsage: "<id>",
+					Action:    handleScheduleRemove,
+				},
+				{
+					Name:   "run",
+					Usage:  "Wait for alarms and start playback when they go off, until stopped with Ctrl-C.",
+					Action: handleScheduleRun,
+				},
+			},
+		},
+		{
+			Name:      "sleep",
+			Category:  "Playback",
+			Usage:     "Pause playback after some time, i.e. '30m', or at the end of some tracks. Keeps running until then, so run it in the background with '&' to keep using the terminal.",
+			ArgsUsage: "<duration> | --end-of-track | --after <n> tracks",
+			Action:    handleSleep,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "end-of-track", Usage: "Pause at the end of the current track."},
+				&cli.IntFlag{Name: "after", Usage: "Pause at the end of this many tracks, counting the current one."},
+				&cli.DurationFlag{Name: "fade", Usage: "Lower the volume to silence over this time before pausing, i.e. '1m'. The volume is restored once paused."},
+			},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "cancel",
+					Usage:  "Stop the sleep timer running in the background.",
+					Action: handleSleepCancel,
+				},
+			},
 		},
 		{
 			Name:     "next",
@@ -66,10 +151,33 @@ func main() {
 		{
 			Name:      "volume",
 			Category:  "Playback",
-			Usage:     "Adjust the volume.",
+			Usage:     "Set the volume, i.e. '40', or change it with '+10', '-5', 'up' or 'down' by 'VolumeStep' of the config file. Use 'fade 20 --over 30s' to change it gradually.",
 			Aliases:   []string{"v"},
 			Action:    handleVolume,
-			ArgsUsage: "<volume-percent>",
+			ArgsUsage: "<volume-percent> | fade <volume-percent> --over <duration>",
+			// Changes like '-5' are not flags.
+			SkipFlagParsing: true,
+		},
+		{
+			Name:     "mute",
+			Category: "Playback",
+			Usage:    "Mute the volume, remembering it for `unmute`.",
+			Action:   handleMute,
+		},
+		{
+			Name:     "unmute",
+			Category: "Playback",
+			Usage:    "Restore the volume from before `mute`.",
+			Action:   handleUnmute,
+		},
+		{
+			Name:      "seek",
+			Category:  "Playback",
+			Usage:     "Jump to a position in the track: a time like '1:30', '+15s' or '-10' from the current one, or '50%' of the track.",
+			Action:    handleSeek,
+			ArgsUsage: "<position>",
+			// Relative positions like '-10' are not flags.
+			SkipFlagParsing: true,
 		},
 		{
 			Name:      "shuffle",
@@ -79,6 +187,37 @@ func main() {
 			Action:    handleShuffle,
 			ArgsUsage: "{on|off}",
 		},
+		{
+			Name:      "repeat",
+			Category:  "Playback",
+			Usage:     "Set the repeat mode, or cycle through off, context and track without a mode.",
+			Aliases:   []string{"r"},
+			Action:    handleRepeat,
+			ArgsUsage: "[off|context|track]",
+		},
+		{
+			Name:     "queue",
+			Category: "Playback",
+			Usage:    "Line up tracks to play after the current one, without interrupting it.",
+			Aliases:  []string{"q"},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "add",
+					Usage:  "Add a track, or all tracks of an album, to the queue.",
+					Action: handleQueueAdd,
+					Flags: []cli.Flag{
+						&cli.StringFlag{Name: "track", Aliases: []string{"t"}, Usage: "A track to queue."},
+						&cli.StringFlag{Name: "album", Aliases: []string{"m"}, Usage: "An album to queue, track by track."},
+						&cli.StringFlag{Name: "uri", Aliases: []string{"u"}, Usage: "A track, episode or album to queue, by using spotify uri.(format: spotify:<type>:<id>)"},
+					},
+				},
+				{
+					Name:   "list",
+					Usage:  "Show what is playing and what plays next.",
+					Action: handleQueueList,
+				},
+			},
+		},
 		// Define Info category commands.
 		{
 			Name:     "devices",
@@ -103,197 +242,697 @@ func main() {
 			Action:   handleSave,
 		},
 		// Define Config category commands.
+		{
+			Name:     "auth",
+			Category: "Configuration",
+			Usage:    "Manage the Spotify login.",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "login",
+					Usage:  "Log in to Spotify, replacing any cached login.",
+					Action: handleLogin,
+					Flags:  loginFlags,
+				},
+				{
+					Name:   "logout",
+					Usage:  "Remove the cached access tokens.",
+					Action: handleLogout,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{Name: "forget", Usage: "Also forget the refresh token, so that the next command asks you to log in again."},
+					},
+				},
+				{
+					Name:   "status",
+					Usage:  "Show who is logged in, the granted scopes and when the tokens expire.",
+					Action: handleAuthStatus,
+				},
+			},
+		},
+		{
+			Name:   "login",
+			Usage:  "Log in to Spotify. Same as `auth login`.",
+			Hidden: true,
+			Action: handleLogin,
+			Flags:  loginFlags,
+		},
+		{
+			Name:     "profile",
+			Category: "Configuration",
+			Usage:    "Manage profiles, each logged in to its own Spotify account.",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List profiles. The one in use is marked with '*'.",
+					Action: handleProfileList,
+				},
+				{
+					Name:      "add",
+					Usage:     "Add a profile.",
+					ArgsUsage: "name",
+					Action:    handleProfileAdd,
+				},
+				{
+					Name:      "remove",
+					Usage:     "Remove a profile with its tokens and config overrides.",
+					ArgsUsage: "name",
+					Action:    handleProfileRemove,
+				},
+				{
+					Name:      "use",
+					Usage:     "Use a profile unless another one is selected with --profile.",
+					ArgsUsage: "name",
+					Action:    handleProfileUse,
+				},
+			},
+		},
 		{
 			Name:     "config",
 			Category: "Configuration",
 			Usage:    "Configure spotify-cli settings.",
 			Aliases:  []string{"c"},
-			Action:   handleConfig,
-			Flags: []cli.Flag{
-				&cli.StringFlag{Name: "set-app-client-id", Usage: "Set 'AppClientID'"},
-				&cli.StringFlag{Name: "set-app-client-secret", Usage: "Set 'AppClientSecret'"},
-				&cli.StringFlag{Name: "set-redirect-port", Usage: "Set 'RedirectPort'"},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "init",
+					Usage:  "Set up spotify-cli step by step: enter your app's credentials, have them checked and log in.",
+					Action: handleConfigInit,
+					Flags:  loginFlags[:1],
+				},
+				{
+					Name:      "get",
+					Usage:     "Print the value of a setting.",
+					ArgsUsage: "key",
+					Action:    handleConfigGet,
+				},
+				{
+					Name:      "set",
+					Usage:     "Set a setting, i.e. 'config set RedirectPort 5555'. Lists are separated by commas.",
+					ArgsUsage: "key value",
+					Action:    handleConfigSet,
+				},
+				{
+					Name:      "unset",
+					Usage:     "Unset a setting, so that its default is used.",
+					ArgsUsage: "key",
+					Action:    handleConfigUnset,
+				},
+				{
+					Name:    "list",
+					Usage:   "List the settings in effect.",
+					Aliases: []string{"show"},
+					Action:  handleConfigList,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{Name: "sources", Usage: "Also show where each setting came from: a flag, an environment variable, the profile, the config file or the defaults."},
+					},
+				},
 			},
 		},
 	}
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+	wrapActions(app.Commands, withConsent)
+
+	// Errors are rendered by main so that the exit code reflects what went wrong.
+	app.ExitErrHandler = func(c *cli.Context, err error) {}
+
+	if err := app.Run(os.Args); err != nil {
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// loginFlags are shared by `auth login` and its `login` shortcut.
+var loginFlags = []cli.Flag{
+	&cli.BoolFlag{Name: "no-browser", Usage: "Print the login URL and paste back the redirect URL, for machines without a browser i.e. over SSH.", EnvVars: []string{"SPOTIFY_CLI_NO_BROWSER"}},
+	&cli.StringSliceFlag{Name: "scope", Usage: "Also ask for this scope, i.e. 'user-top-read'. Can be repeated."},
+}
+
+// Exit codes returned by spotify-cli.
+const (
+	exitFailure = 1 // Any error not covered below
+	exitUsage   = 2 // Bad positional arguments or flags
+	exitAuth    = 3 // Authorization with Spotify failed
+	exitAPI     = 4 // The Spotify Web API rejected a request
+
+	exitInterrupted = 130 // Aborted with Ctrl-C, as shells report it
+)
+
+// exitCode picks the exit code that best describes err.
+func exitCode(err error) int {
+	var exitCoder cli.ExitCoder
+	var apiErr *spotify.APIError
+	switch {
+	case errors.As(err, &exitCoder):
+		return exitCoder.ExitCode()
+	case errors.As(err, new(*spotify.ScopeError)):
+		return exitAuth
+	case errors.As(err, &apiErr):
+		if apiErr.Operation == "Authorize" || apiErr.StatusCode == http.StatusUnauthorized {
+			return exitAuth
+		}
+		return exitAPI
+	}
+	return exitFailure
+}
+
+// wrapActions wraps the actions of cmds and of their subcommands with wrap.
+func wrapActions(cmds []*cli.Command, wrap func(cli.ActionFunc) cli.ActionFunc) {
+	for _, cmd := range cmds {
+		if cmd.Action != nil {
+			cmd.Action = wrap(cmd.Action)
+		}
+		wrapActions(cmd.Subcommands, wrap)
+	}
+}
+
+// partialError is returned by actions that failed after making some of their
+// changes, which running them again would repeat.
+type partialError struct {
+	error
+}
+
+// Unwrap returns the error the action failed with.
+func (e partialError) Unwrap() error {
+	return e.error
+}
+
+// withConsent wraps action so that when it fails for lack of scopes, the user
+// is asked to log in again granting them and action is retried, unless it
+// made changes already. When nobody can answer, the error explains how to
+// grant them instead.
+func withConsent(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		err := action(c)
+		var scopeErr *spotify.ScopeError
+		if !errors.As(err, &scopeErr) {
+			return err
+		}
+
+		partlyDone := errors.As(err, new(partialError))
+		loginCmd := "auth login"
+		for _, scope := range scopeErr.Missing {
+			loginCmd += " --scope " + scope
+		}
+		if !utils.IsTerminal(os.Stdin) {
+			return cli.Exit(fmt.Sprintf("%v. Use `%s` to grant them.", err, loginCmd), exitAuth)
+		}
+
+		fmt.Printf("%v.\nLog in again to grant them? [Y/n] ", err)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+			return cli.Exit(fmt.Sprintf("Not logged in again. Use `%s` to grant them later.", loginCmd), exitAuth)
+		}
+
+		Spotify, err := loadSpotify(c)
+		if err != nil {
+			return err
+		}
+		if err := Spotify.Login(scopeErr.Missing...); err != nil {
+			return err
+		}
+		if partlyDone {
+			return cli.Exit("Logged in again. The command was not run again since it was partly done already.", exitFailure)
+		}
+		return action(c)
 	}
 }
 
-func getConfig() *spotify.ConfigT {
+// helpAsked reports whether help was asked for with -h or --help, for
+// commands that skip flag parsing to accept negative numbers.
+func helpAsked(c *cli.Context) bool {
+	arg := c.Args().First()
+	return arg == "-h" || arg == "--help"
+}
 
-	progFilesDir := utils.GetProgFilesDir()
-	// Make sure ~/.spotify-cli exists, create if not
-	_ = os.Mkdir(progFilesDir, 0700)
-	configPath := filepath.Join(progFilesDir, ConfigFile)
+// flagsAnywhere parses the flags of a command that skips flag parsing, so
+// that they may also come after its arguments, as in
+// `schedule add "07:00 weekdays" --playlist morning`. Commands with
+// subcommands, like `sleep`, cannot skip flag parsing: those before the
+// arguments were parsed already and are kept. Asking for help returns
+// flag.ErrHelp.
+func flagsAnywhere(c *cli.Context) (*cli.Context, error) {
+	name, flags := c.Command.Name, c.Command.Flags
+	if name == "" {
+		// The action of a command with subcommands runs as an app of its own.
+		name, flags = c.App.Name, c.App.Flags
+	}
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range flags {
+		if err := f.Apply(set); err != nil {
+			return nil, err
+		}
+	}
+	for _, parsed := range c.LocalFlagNames() {
+		if err := set.Set(parsed, fmt.Sprint(c.Value(parsed))); err != nil {
+			return nil, err
+		}
+	}
 
-	cfg, created := spotify.LoadConfig(configPath)
-	if created {
-		fmt.Printf("No config file was found, so one was created for you at `%s`.\n", configPath)
-		fmt.Printf("Edit the config file with your Spotify Application credentials or use the command `config` to help you.\n")
-		spotify.SaveConfig(cfg, configPath)
-		os.Exit(0)
+	args := c.Args().Slice()
+	var positional []string
+	for {
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+		if set.NArg() == 0 {
+			break
+		}
+		positional = append(positional, set.Arg(0))
+		args = set.Args()[1:]
+	}
+	if err := set.Parse(append([]string{"--"}, positional...)); err != nil {
+		return nil, err
+	}
+	for _, name := range cli.HelpFlag.Names() {
+		if help := set.Lookup(name); help != nil && help.Value.String() == "true" {
+			return nil, flag.ErrHelp
+		}
 	}
-	return cfg
+	return cli.NewContext(c.App, set, c), nil
 }
 
-func handleConfig(c *cli.Context) error {
-	progFilesDir := utils.GetProgFilesDir()
-	// Make sure ~/.spotify-cli exists, create if not
-	_ = os.Mkdir(progFilesDir, 0700)
-	configPath := filepath.Join(progFilesDir, ConfigFile)
-	cfg, _ := spotify.LoadConfig(configPath)
+// usageError reports bad positional arguments or flags.
+func usageError(format string, a ...interface{}) error {
+	return cli.Exit(fmt.Sprintf(format, a...), exitUsage)
+}
 
-	id, secret, port := c.String("set-app-client-id"), c.String("set-app-client-secret"), c.String("set-redirect-port")
+// clientOptions builds the spotify.Options described by the global flags and
+// the settings of cfg.
+func clientOptions(c *cli.Context, cfg *spotify.ConfigT) (spotify.Options, error) {
+	p, err := selectedProfile(c)
+	if err != nil {
+		return spotify.Options{}, err
+	}
+	opts := spotify.Options{
+		TokenFile:    p.TokenFile(),
+		APIURL:       c.String("api-url"),
+		AccountsURL:  c.String("accounts-url"),
+		Timeout:      time.Duration(cfg.RequestTimeout),
+		LoginTimeout: time.Duration(cfg.LoginTimeout),
+	}
+	if c.IsSet("timeout") {
+		opts.Timeout = c.Duration("timeout")
+	} else if opts.Timeout == 0 {
+		opts.Timeout = spotify.DefaultRequestTimeout
+	}
+	if cfg.MaxRetries != nil || cfg.RetryMaxWait != 0 {
+		policy := spotify.DefaultRetryPolicy
+		if cfg.MaxRetries != nil {
+			policy.MaxRetries = *cfg.MaxRetries
+		}
+		if cfg.RetryMaxWait != 0 {
+			policy.MaxWait = time.Duration(cfg.RetryMaxWait)
+		}
+		opts.Retry = &policy
+	}
+	if proxy := c.String("proxy"); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return opts, usageError("Invalid --proxy URL '%s': %v", proxy, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		opts.HTTPClient = &http.Client{Transport: transport}
+	}
+	return opts, nil
+}
 
-	if id != "" {
-		cfg.AppClientID = id
-		fmt.Printf("Set AppClientID.\n")
+// loadSpotify loads the config and returns a Spotify that is not authorized yet.
+func loadSpotify(c *cli.Context) (*spotify.Spotify, error) {
+	cfg, err := getConfig(c)
+	if err != nil {
+		return nil, err
 	}
+	return spotifyFor(c, cfg, nil)
+}
 
-	if secret != "" {
-		cfg.AppClientSecret = secret
-		fmt.Printf("Set AppClientSecret.\n")
+// spotifyFor returns a Spotify for cfg that is not authorized yet. Pasted
+// redirect URLs are read from stdin, or os.Stdin if it is nil.
+func spotifyFor(c *cli.Context, cfg *spotify.ConfigT, stdin io.Reader) (*spotify.Spotify, error) {
+	opts, err := clientOptions(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts.NoBrowser = c.Bool("no-browser")
+	opts.Stdin = stdin
+	if opts.TokenStore, err = tokenStore(cfg, opts.TokenFile); err != nil {
+		return nil, err
 	}
+	return spotify.New(cfg, opts), nil
+}
 
-	if port != "" {
-		cfg.RedirectPort = port
-		fmt.Printf("Set RedirectPort.\n")
+// tokenStore returns the store for the tokens saved at tokenFile, as chosen
+// by the TokenStorage setting. Encrypted tokens use the passphrase in
+// $SPOTIFY_CLI_TOKEN_PASSPHRASE or else a key file next to the tokens.
+func tokenStore(cfg *spotify.ConfigT, tokenFile string) (spotify.TokenStore, error) {
+	switch cfg.TokenStorage {
+	case spotify.TokenStorageMemory:
+		return spotify.NewMemoryTokenStore(), nil
+	case spotify.TokenStorageEncrypted:
+		if passphrase := os.Getenv("SPOTIFY_CLI_TOKEN_PASSPHRASE"); passphrase != "" {
+			return spotify.NewEncryptedFileTokenStore(tokenFile, []byte(passphrase)), nil
+		}
+		key, err := spotify.LoadOrCreateKeyFile(tokenFile + ".key")
+		if err != nil {
+			return nil, err
+		}
+		return spotify.NewEncryptedFileTokenStore(tokenFile, key), nil
 	}
+	return spotify.NewFileTokenStore(tokenFile), nil
+}
 
-	spotify.SaveConfig(cfg, configPath)
+// newSpotify loads the config and returns an authorized Spotify.
+func newSpotify(c *cli.Context) (*spotify.Spotify, error) {
+	Spotify, err := loadSpotify(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := Spotify.Authorize(); err != nil {
+		return nil, err
+	}
+	return Spotify, nil
+}
 
-	if cfg.Validate() != nil {
-		fmt.Printf("Configs were saved but errors were found.\n")
-		fmt.Printf("For help setting these configs view the README.md or visit http://github.com/charlesyu108/spotify-cli.\n")
-		os.Exit(1)
+func handleLogin(c *cli.Context) error {
+	Spotify, err := loadSpotify(c)
+	if err != nil {
+		return err
+	}
+	if err := Spotify.Login(c.StringSlice("scope")...); err != nil {
+		return err
 	}
+	fmt.Printf("Logged in.\n")
+	return nil
+}
 
+func handleLogout(c *cli.Context) error {
+	Spotify, err := loadSpotify(c)
+	if err != nil {
+		return err
+	}
+	if err := Spotify.Logout(c.Bool("forget")); err != nil {
+		return err
+	}
+	if c.Bool("forget") {
+		fmt.Printf("Logged out. The next command will ask you to log in again.\n")
+	} else {
+		fmt.Printf("Logged out. Use `auth logout --forget` to also forget the refresh token.\n")
+	}
 	return nil
 }
 
+func handleAuthStatus(c *cli.Context) error {
+	Spotify, err := loadSpotify(c)
+	if err != nil {
+		return err
+	}
+
+	info, err := Spotify.TokenInfo()
+	if err != nil {
+		return err
+	}
+	if !info.LoggedIn {
+		fmt.Printf("Not logged in. Use `auth login` to log in.\n")
+		return nil
+	}
+
+	// The user token is refreshed if needed, but nobody is asked to log in.
+	user, err := Spotify.CurrentUser()
+	if err != nil {
+		return err
+	}
+	name := user.ID
+	if user.DisplayName != "" {
+		name = fmt.Sprintf("%s (%s)", user.DisplayName, user.ID)
+	}
+	fmt.Printf("Logged in as:\t%s\n", name)
+
+	if info, err = Spotify.TokenInfo(); err != nil {
+		return err
+	}
+	scopes := strings.Join(info.Scopes, " ")
+	if scopes == "" {
+		scopes = "unknown, log in again to find out"
+	}
+	fmt.Printf("Scopes:\t\t%s\n", scopes)
+	fmt.Printf("User token:\t%s\n", describeExpiration(info.UserTokenValid, info.UserTokenExpiration))
+	if Spotify.Config.AuthFlow == spotify.AuthFlowPKCE {
+		fmt.Printf("App token:\tnot used with PKCE\n")
+	} else {
+		fmt.Printf("App token:\t%s\n", describeExpiration(info.AppTokenValid, info.AppTokenExpiration))
+	}
+	return nil
+}
+
+// describeExpiration tells whether a token is valid and when it expires.
+func describeExpiration(valid bool, expiration time.Time) string {
+	if !valid {
+		return "expired, it is refreshed on the next command"
+	}
+	return fmt.Sprintf("valid until %s", expiration.Format("2006-01-02 15:04:05"))
+}
+
 func handlePlay(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
+	start, err := parsePlayStart(c)
+	if err != nil {
+		return err
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
 
 	device := c.String("device")
 	track := c.String("track")
 	album := c.String("album")
 	artist := c.String("artist")
 	playlist := c.String("playlist")
-	uri:= c.String("uri")
-
-	switch true {
-	case device != "":
-		search := strings.ToLower(device)
-		for _, d := range Spotify.GetDevices() {
+	uri := c.String("uri")
 
-			id, name, t := strings.ToLower(d.ID), strings.ToLower(d.Name), strings.ToLower(d.Type)
-			if strings.Contains(id, search) ||
-				strings.Contains(name, search) ||
-				strings.Contains(t, search) {
+	var target spotify.Device
+	if device != "" {
+		if target, err = Spotify.FindDevice(device); err != nil {
+			return err
+		}
+		start.device = target
+	}
 
-				Spotify.PlayOnDevice(d)
-				return nil
+	fade := newFadeIn(context.Background(), Spotify, c.Duration("fade-in"))
+	if fade.over > 0 {
+		// Listen for Ctrl-C before silencing, so that the volume is restored.
+		var stop func()
+		fade.ctx, stop = interruptible()
+		defer stop()
+		if device == "" {
+			if target, err = Spotify.PlaybackDevice(); err != nil {
+				return err
 			}
 		}
-		fmt.Printf("Could not find any devices '%s'.\n", device)
-		os.Exit(1)
+		if err := fade.start(target); err != nil {
+			return err
+		}
+	}
+
+	// Without anything to play, --device moves the playback over to it.
+	transfer := device != "" && track == "" && album == "" && artist == "" && playlist == "" && uri == ""
+	switch true {
+	case transfer:
+		err = Spotify.PlayOnDevice(target)
+		if err == nil && start.position > 0 {
+			err = Spotify.Seek(start.position)
+		}
 
 	case track != "":
-		uri := Spotify.SimpleSearch(track, "track")
-		Spotify.PlayURI(uri)
+		err = searchAndPlay(Spotify, track, "track", start)
 
 	case album != "":
-		uri := Spotify.SimpleSearch(album, "album")
-		Spotify.PlayURI(uri)
+		err = searchAndPlay(Spotify, album, "album", start)
 
 	case artist != "":
-		uri := Spotify.SimpleSearch(artist, "artist")
-		Spotify.PlayURI(uri)
+		err = searchAndPlay(Spotify, artist, "artist", start)
 
 	case playlist != "":
-		uri := Spotify.SimpleSearch(playlist, "playlist")
-		Spotify.PlayURI(uri)
+		err = searchAndPlay(Spotify, playlist, "playlist", start)
 
 	case uri != "":
-		suri:= spotify.SpotifyURI(uri)
-		Spotify.PlayURI(suri)
+		suri := spotify.SpotifyURI(uri)
+		err = playURIAt(Spotify, suri, start)
 
 	default:
-		Spotify.Play()
+		err = Spotify.Play()
+		if err == nil && start.position > 0 {
+			err = Spotify.Seek(start.position)
+		}
+	}
+	if err != nil {
+		fade.abort()
+		return err
 	}
 
-	defer deferredTrackInfo(&Spotify)
-
+	if !transfer {
+		if err := deferredTrackInfo(Spotify); err != nil {
+			return err
+		}
+	}
+	if fade.over > 0 {
+		return fade.finish()
+	}
 	return nil
 }
 
+// searchAndPlay plays the first search result of the given type matching q,
+// starting where start says.
+func searchAndPlay(Spotify *spotify.Spotify, q string, Type string, start playStart) error {
+	uri, err := Spotify.SimpleSearch(q, Type)
+	if err != nil {
+		return err
+	}
+	return playURIAt(Spotify, uri, start)
+}
+
+// playStart is where in the played album, playlist or track playback starts.
+type playStart struct {
+	offset    int            // Number of tracks to skip
+	fromTrack string         // Name of the track to start at, instead of skipping tracks
+	position  time.Duration  // Position in the first track
+	device    spotify.Device // Device to play on, if its ID is set
+}
+
+// parsePlayStart parses the --offset, --from-track and --position flags of
+// `play`.
+func parsePlayStart(c *cli.Context) (playStart, error) {
+	start := playStart{offset: c.Int("offset"), fromTrack: c.String("from-track")}
+	if start.offset < 0 {
+		return start, usageError("--offset must not be negative, not %d.", start.offset)
+	}
+	if start.offset > 0 && start.fromTrack != "" {
+		return start, usageError("Only one of --offset or --from-track can be given.")
+	}
+	if (start.offset > 0 || start.fromTrack != "") && c.String("album") == "" && c.String("playlist") == "" && c.String("uri") == "" {
+		return start, usageError("--offset and --from-track need an --album, --playlist or --uri to play.")
+	}
+	if position := c.String("position"); position != "" {
+		var err error
+		if start.position, err = parseClockDuration(position); err != nil {
+			return start, usageError("Usage: play --position <position>: %v.", err)
+		}
+	}
+	return start, nil
+}
+
+// playURIAt plays uri, starting where start says.
+func playURIAt(Spotify *spotify.Spotify, uri spotify.SpotifyURI, start playStart) error {
+	opts := spotify.PlayOptions{Offset: start.offset, Position: start.position, Device: start.device}
+	if start.fromTrack != "" {
+		var err error
+		if opts.OffsetURI, err = findContextTrack(Spotify, uri, start.fromTrack); err != nil {
+			return err
+		}
+	}
+	return Spotify.PlayURIAt(uri, opts)
+}
+
+// findContextTrack returns the track of the album or playlist uri named name,
+// or else the first one whose name contains it, ignoring case.
+func findContextTrack(Spotify *spotify.Spotify, uri spotify.SpotifyURI, name string) (spotify.SpotifyURI, error) {
+	var tracks []spotify.Track
+	var err error
+	switch uri.Type() {
+	case "album":
+		tracks, err = Spotify.AlbumTracks(uri)
+	case "playlist":
+		tracks, err = Spotify.PlaylistTracks(uri)
+	default:
+		return "", usageError("--from-track needs an album or playlist to play, not %s.", uri)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var found spotify.SpotifyURI
+	for _, t := range tracks {
+		if strings.EqualFold(t.Name, name) {
+			return t.URI, nil
+		}
+		if found == "" && strings.Contains(strings.ToLower(t.Name), strings.ToLower(name)) {
+			found = t.URI
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("could not find any track '%s' in %s: %w", name, uri, spotify.ErrNoResults)
+	}
+	return found, nil
+}
+
 func handlePause(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
-	Spotify.Pause()
-	return nil
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	if fade := c.Duration("fade"); fade > 0 {
+		return fadeOutAndPause(Spotify, fade)
+	}
+	return Spotify.Pause()
 }
 
 func handleNextTrack(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
-	Spotify.NextTrack()
-	defer deferredTrackInfo(&Spotify)
-	return nil
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	if err := Spotify.NextTrack(); err != nil {
+		return err
+	}
+	return deferredTrackInfo(Spotify)
 }
 
 func handlePrevTrack(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
-	Spotify.PreviousTrack()
-	defer deferredTrackInfo(&Spotify)
-	return nil
-}
-
-func handleVolume(c *cli.Context) error {
-	volArg := c.Args().Get(0)
-	if volArg == "" {
-		fmt.Printf("Positional argument `volume-percent` not provided.\n")
-		os.Exit(1)
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
 	}
-	vol, _ := strconv.Atoi(volArg)
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
-	Spotify.Volume(vol)
-	return nil
+	if err := Spotify.PreviousTrack(); err != nil {
+		return err
+	}
+	return deferredTrackInfo(Spotify)
 }
 
 func handleDevices(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	devices, err := Spotify.GetDevices()
+	if err != nil {
+		return err
+	}
+	if Spotify.Config.OutputFormat == spotify.OutputJSON {
+		return printJSON(devices)
+	}
 	fmt.Printf("[DeviceID]\t\t\t\t\tDeviceType\tName\n")
-	for _, d := range Spotify.GetDevices() {
+	for _, d := range devices {
 		fmt.Printf("[%s]\t%s\t%s\n", d.ID, d.Type, d.Name)
 	}
 	return nil
 }
 
 func handleInfo(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
-	displayTrackInfo(&Spotify)
-	return nil
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	return displayTrackInfo(Spotify)
 }
 
-func displayTrackInfo(spotify *spotify.Spotify) {
-	state := spotify.CurrentState()
+func displayTrackInfo(Spotify *spotify.Spotify) error {
+	state, err := Spotify.CurrentState()
+	if err != nil {
+		return err
+	}
+	if Spotify.Config.OutputFormat == spotify.OutputJSON {
+		return printJSON(state)
+	}
 	isPlayingDesc := "Paused"
 	if state.IsPlaying {
 		isPlayingDesc = "Playing"
@@ -301,62 +940,124 @@ func displayTrackInfo(spotify *spotify.Spotify) {
 
 	var trackInfo string
 	if state.Track.Name != "" {
-		artistNames := []string{}
-		for _, art := range state.Track.Artists {
-			artistNames = append(artistNames, art.Name)
-		}
-		artistsString := strings.Join(artistNames, ", ")
-		trackInfo = fmt.Sprintf(":: %s - %s", state.Track.Name, artistsString)
+		trackInfo = ":: " + describeTrack(state.Track)
+	}
+
+	repeat := state.RepeatState
+	if repeat == "" {
+		repeat = "off"
+	}
+	fmt.Printf("=> %s %s [repeat: %s]\n", isPlayingDesc, trackInfo, repeat)
+	return nil
+}
+
+// describeTrack names a track and its artists, i.e. "Borderline - Tame Impala".
+func describeTrack(track spotify.Track) string {
+	artistNames := []string{}
+	for _, art := range track.Artists {
+		artistNames = append(artistNames, art.Name)
 	}
+	return fmt.Sprintf("%s - %s", track.Name, strings.Join(artistNames, ", "))
+}
 
-	fmt.Printf("=> %s %s\n", isPlayingDesc, trackInfo)
+// printJSON prints v as indented JSON, for the 'json' OutputFormat.
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
 }
 
-// Use in a defer to chain track info display after a playback operation.
-func deferredTrackInfo(spotify *spotify.Spotify) {
+// Use after a playback operation to chain track info display once Spotify
+// has caught up with the change.
+func deferredTrackInfo(spotify *spotify.Spotify) error {
 	time.Sleep(200 * time.Millisecond)
-	displayTrackInfo(spotify)
+	return displayTrackInfo(spotify)
 }
 
 func handleShuffle(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
 
 	switch shuffleArg := c.Args().Get(0); shuffleArg {
 	case "on":
-		Spotify.ToggleShuffle(true)
+		if err := Spotify.ToggleShuffle(true); err != nil {
+			return err
+		}
 		fmt.Printf("Shuffle toggled on.\n")
 	case "off":
-		Spotify.ToggleShuffle(false)
+		if err := Spotify.ToggleShuffle(false); err != nil {
+			return err
+		}
 		fmt.Printf("Shuffle toggled off.\n")
 	default:
-		fmt.Printf("Positional argument `toggle` must be one of {on | off}.\n")
-		os.Exit(1)
+		return usageError("Positional argument `toggle` must be one of {on | off}.")
 	}
 	return nil
 }
 
+// nextRepeatMode returns the mode `repeat` cycles to from mode, in the
+// order of the Spotify apps.
+func nextRepeatMode(mode string) string {
+	switch mode {
+	case spotify.RepeatContext:
+		return spotify.RepeatTrack
+	case spotify.RepeatTrack:
+		return spotify.RepeatOff
+	}
+	return spotify.RepeatContext
+}
+
+func handleRepeat(c *cli.Context) error {
+	mode := c.Args().Get(0)
+	switch mode {
+	case "", spotify.RepeatOff, spotify.RepeatContext, spotify.RepeatTrack:
+	default:
+		return usageError("Positional argument `mode` must be one of {off | context | track}.")
+	}
+
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		state, err := Spotify.CurrentState()
+		if err != nil {
+			return err
+		}
+		mode = nextRepeatMode(state.RepeatState)
+	}
+	if err := Spotify.SetRepeat(mode); err != nil {
+		return err
+	}
+	fmt.Printf("Repeat set to %s.\n", mode)
+	return nil
+}
+
 func handleSave(c *cli.Context) error {
-	cfg := getConfig()
-	Spotify := spotify.New(cfg)
-	Spotify.Authorize()
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
 
-	state := Spotify.CurrentState()
+	state, err := Spotify.CurrentState()
+	if err != nil {
+		return err
+	}
 	if !state.IsPlaying || state.Track.URI == "" {
-		fmt.Printf("Error to save. Playback is paused or nothing is playing.\n")
-		os.Exit(1)
+		return cli.Exit("Error to save. Playback is paused or nothing is playing.", exitFailure)
 	}
 
 	trackID := strings.Replace(string(state.Track.URI), "spotify:track:", "", -1)
-	Spotify.SaveTrack(trackID)
-
-	artistNames := []string{}
-	for _, art := range state.Track.Artists {
-		artistNames = append(artistNames, art.Name)
+	if err := Spotify.SaveTrack(trackID); err != nil {
+		return err
 	}
-	artistsString := strings.Join(artistNames, ", ")
-	fmt.Printf("Saved track '%s - %s' to library.\n", state.Track.Name, artistsString)
+
+	fmt.Printf("Saved track '%s' to library.\n", describeTrack(state.Track))
 
 	return nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+	"github.com/urfave/cli/v2"
+)
+
+func TestPlayURIAt(t *testing.T) {
+	bedroom := spotify.Device{ID: "1a2b3c4d", Name: "Bedroom", Type: "Speaker"}
+	tracks := []spotifytest.Item{
+		{Type: "track", Name: "Let It Happen", URI: "spotify:track:2X485T9Z5Ly0xyaghN73ed", Artists: []string{"Tame Impala"}, Duration: 467 * time.Second},
+		{Type: "track", Name: "Eventually", URI: "spotify:track:1rsbDvZqs7y5Oo2n6YnCw4", Artists: []string{"Tame Impala"}, Duration: 319 * time.Second},
+	}
+	currents := spotifytest.Item{Type: "album", Name: "Currents", URI: "spotify:album:79dL7FLiJFOO0EoehUHQBv", Tracks: tracks}
+	tests := []struct {
+		name           string
+		start          playStart
+		expectedDevice string
+		expectedIndex  int
+	}{
+		{"Active device", playStart{offset: 1}, "7bc21f5e", 1},
+		{"Other device", playStart{device: bedroom}, bedroom.ID, 0},
+		{"Other device from a track", playStart{fromTrack: "eventually", device: bedroom}, bedroom.ID, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := spotifytest.NewServer()
+			t.Cleanup(srv.Close)
+			s := newPlayingSpotify(t, srv)
+			srv.AddDevice(bedroom)
+			srv.AddItem(currents)
+
+			if err := playURIAt(s, currents.URI, tt.start); err != nil {
+				t.Fatalf("playURIAt returned %v", err)
+			}
+			p := srv.Player()
+			if !p.IsPlaying || p.DeviceID != tt.expectedDevice || p.Context != currents.URI || p.Index != tt.expectedIndex {
+				t.Errorf("Expected track %d of %s to play on %s but got %+v", tt.expectedIndex, currents.URI, tt.expectedDevice, p)
+			}
+		})
+	}
+}
+
+func TestFlagsAnywhere(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		expectedArgs []string
+		expectedFade time.Duration
+		expectHelp   bool
+	}{
+		{"Flags first", []string{"--fade", "1m", "30m"}, []string{"30m"}, time.Minute, false},
+		{"Flags last", []string{"30m", "--fade", "1m"}, []string{"30m"}, time.Minute, false},
+		{"No flags", []string{"07:00", "weekdays"}, []string{"07:00", "weekdays"}, 0, false},
+		{"Help last", []string{"30m", "-h"}, nil, 0, true},
+	}
+	flags := []cli.Flag{&cli.DurationFlag{Name: "fade"}}
+	for _, command := range []*cli.Command{
+		{Name: "skipping", Flags: flags, SkipFlagParsing: true},
+		{Name: "with-subcommands", Flags: flags, Subcommands: []*cli.Command{{Name: "cancel", Action: func(*cli.Context) error { return nil }}}},
+	} {
+		for _, tt := range tests {
+			t.Run(command.Name+"/"+tt.name, func(t *testing.T) {
+				var parsed *cli.Context
+				var err error
+				command.Action = func(c *cli.Context) error {
+					parsed, err = flagsAnywhere(c)
+					return nil
+				}
+				app := &cli.App{Name: "spotify-cli", Commands: []*cli.Command{command}}
+				if runErr := app.Run(append([]string{"spotify-cli", command.Name}, tt.args...)); runErr != nil {
+					t.Fatalf("Run returned %v", runErr)
+				}
+				if tt.expectHelp {
+					if !errors.Is(err, flag.ErrHelp) {
+						t.Errorf("Expected flag.ErrHelp but got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("flagsAnywhere returned %v", err)
+				}
+				if args := parsed.Args().Slice(); !reflect.DeepEqual(args, tt.expectedArgs) || parsed.Duration("fade") != tt.expectedFade {
+					t.Errorf("Expected %v with --fade %v but got %v with --fade %v", tt.expectedArgs, tt.expectedFade, args, parsed.Duration("fade"))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package spotify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+)
+
+// tokensT defines tokens and related info
+// for interfacing with the Spotify API
+type tokensT struct {
+	AppAccessToken      string
+	UserAccessToken     string
+	UserRefreshToken    string
+	UserTokenExpiration int64
+	AppTokenExpiration  int64
+	UserScopes          []string // Scopes the user granted
+}
+
+// Tokens are refreshed tokenExpirySkew before they expire, so they
+// do not expire while a request is in flight.
+const (
+	tokenExpirySkew      = 60 * time.Second
+	defaultTokenLifetime = time.Hour
+)
+
+// userTokenValid reports whether the user access token can still be used at now.
+func (t *tokensT) userTokenValid(now time.Time) bool {
+	return t.UserAccessToken != "" && now.Add(tokenExpirySkew).Unix() < t.UserTokenExpiration
+}
+
+// appTokenValid reports whether the app access token can still be used at now.
+func (t *tokensT) appTokenValid(now time.Time) bool {
+	return t.AppAccessToken != "" && now.Add(tokenExpirySkew).Unix() < t.AppTokenExpiration
+}
+
+// Authorize performs the required client and user authorization steps for
+// the app to work properly.
+//
+// NOTE: If the tokens are properly saved, they will cache authorization credentials
+// to make this process more seamless.
+func (spotify *Spotify) Authorize() error {
+	authorized, err := spotify.authorizeSilently()
+	if err != nil || authorized {
+		return err
+	}
+	// Case: New user - getting new auth and refresh tokens
+	return spotify.loginUser(nil, false)
+}
+
+// authorizeSilently authorizes the app and refreshes the cached user tokens
+// if needed. It reports false if the user has to log in.
+func (spotify *Spotify) authorizeSilently() (bool, error) {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	unlock, err := spotify.lockTokens()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if err := spotify.loadSavedTokens(); err != nil {
+		return false, err
+	}
+
+	tokens := spotify.tokens
+	now := time.Now()
+
+	// Always want to make sure our App Client is authorized. Without a
+	// client secret there is no app to authorize, only the user.
+	if !spotify.Config.UsesPKCE() && !tokens.appTokenValid(now) {
+		if err := spotify.acquireTokens("", "client"); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	// Case: user has existing tokens
+	case tokens.userTokenValid(now):
+
+	// Case: Existing user but tokens expired, refresh
+	case tokens.UserRefreshToken != "":
+		if err := spotify.acquireTokens(tokens.UserRefreshToken, "refresh"); err != nil {
+			return false, err
+		}
+
+	default:
+		return false, spotify.saveTokens()
+	}
+	return true, spotify.saveTokens()
+}
+
+// Login authorizes a user from scratch, even if tokens are cached, and
+// caches the new tokens. Use it to switch accounts or repair a broken login.
+// The user is asked to consent to scopes on top of the default, configured
+// and already granted ones, i.e. the Missing scopes of a *ScopeError.
+func (spotify *Spotify) Login(scopes ...string) error {
+	err := func() error {
+		spotify.mu.Lock()
+		defer spotify.mu.Unlock()
+		unlock, err := spotify.lockTokens()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		// Tokens that cannot be read are about to be replaced anyway.
+		if err := spotify.loadSavedTokens(); err != nil && !errors.Is(err, ErrTokensUnreadable) {
+			return err
+		}
+		if spotify.Config.UsesPKCE() || spotify.tokens.appTokenValid(time.Now()) {
+			return nil
+		}
+		if err := spotify.acquireTokens("", "client"); err != nil {
+			return err
+		}
+		return spotify.saveTokens()
+	}()
+	if err != nil {
+		return err
+	}
+	return spotify.loginUser(scopes, true)
+}
+
+// VerifyCredentials checks Spotify accepts AppClientID and AppClientSecret
+// by requesting an app token, without involving the user. The token is kept
+// for a Login that follows. With PKCE there is no secret to check, the
+// client ID is only checked once a user logs in.
+func (spotify *Spotify) VerifyCredentials() error {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	if spotify.Config.UsesPKCE() {
+		return nil
+	}
+	return spotify.acquireTokens("", "client")
+}
+
+// loginUser has the user authorize the app, requesting extra scopes on top
+// of the usual ones, and exchanges the authorization code for user tokens.
+// Neither spotify.mu nor the saved tokens are locked while the user takes
+// their time to log in. Unless replace is set, tokens another process saved
+// meanwhile are kept and the authorization code is dropped.
+func (spotify *Spotify) loginUser(extra []string, replace bool) error {
+	spotify.mu.Lock()
+	scopes := spotify.requestedScopes(extra)
+	spotify.mu.Unlock()
+
+	authCode, err := spotify.authorizeUser(scopes)
+	if err != nil {
+		return err
+	}
+
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	unlock, err := spotify.lockTokens()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := spotify.loadSavedTokens(); err != nil && !(replace && errors.Is(err, ErrTokensUnreadable)) {
+		return err
+	}
+	if !replace && spotify.tokens.userTokenValid(time.Now()) {
+		return nil
+	}
+	if err := spotify.acquireTokens(authCode, "auth"); err != nil {
+		return err
+	}
+	return spotify.saveTokens()
+}
+
+// TokenInfo describes the cached tokens without revealing them.
+type TokenInfo struct {
+	LoggedIn            bool      // Whether a user is logged in, even if the access token expired
+	UserTokenValid      bool      // Whether the user access token can be used right now
+	UserTokenExpiration time.Time // When the user access token expires
+	AppTokenValid       bool      // Whether the app access token can be used right now
+	AppTokenExpiration  time.Time // When the app access token expires
+	Scopes              []string  // Scopes the user granted
+}
+
+// TokenInfo loads the cached tokens and describes them. Unlike Authorize,
+// it never refreshes tokens nor asks the user to log in.
+func (spotify *Spotify) TokenInfo() (TokenInfo, error) {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	if err := spotify.loadSavedTokens(); err != nil {
+		return TokenInfo{}, err
+	}
+
+	tokens, now := spotify.tokens, time.Now()
+	return TokenInfo{
+		LoggedIn:            tokens.UserRefreshToken != "" || tokens.userTokenValid(now),
+		UserTokenValid:      tokens.userTokenValid(now),
+		UserTokenExpiration: time.Unix(tokens.UserTokenExpiration, 0),
+		AppTokenValid:       tokens.appTokenValid(now),
+		AppTokenExpiration:  time.Unix(tokens.AppTokenExpiration, 0),
+		Scopes:              append([]string(nil), tokens.UserScopes...),
+	}, nil
+}
+
+// Logout removes the cached access tokens. Unless forgetRefreshToken is set
+// the refresh token is kept, so the next Authorize logs in again silently.
+// Otherwise the tokens file is deleted and the user has to log in from scratch.
+func (spotify *Spotify) Logout(forgetRefreshToken bool) error {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	unlock, err := spotify.lockTokens()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if forgetRefreshToken {
+		spotify.tokens = new(tokensT)
+		if err := spotify.store.Delete(); err != nil {
+			return fmt.Errorf("could not delete tokens: %w", err)
+		}
+		return nil
+	}
+
+	if err := spotify.loadSavedTokens(); err != nil {
+		return err
+	}
+	spotify.tokens = &tokensT{UserRefreshToken: spotify.tokens.UserRefreshToken, UserScopes: spotify.tokens.UserScopes}
+	return spotify.saveTokens()
+}
+
+// userToken returns a valid user access token for operation, refreshing it
+// first if it expired or is about to. It returns a *ScopeError instead if
+// the user did not grant the scopes operation needs.
+func (spotify *Spotify) userToken(operation string) (string, error) {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	if missing := spotify.missingScopes(operation); len(missing) > 0 {
+		return "", &ScopeError{Operation: operation, Missing: missing}
+	}
+	if !spotify.tokens.userTokenValid(time.Now()) && spotify.tokens.UserRefreshToken != "" {
+		if err := spotify.refreshUserToken(); err != nil {
+			return "", err
+		}
+	}
+	return spotify.tokens.UserAccessToken, nil
+}
+
+// renewUserToken refreshes the user access token after stale was rejected
+// by the Web API and returns the new one. It does not refresh again if another
+// request already replaced stale.
+func (spotify *Spotify) renewUserToken(stale string) (string, error) {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	if spotify.tokens.UserRefreshToken == "" {
+		return "", fmt.Errorf("cannot refresh the access token without a refresh token, please log in again")
+	}
+	if spotify.tokens.UserAccessToken == stale {
+		if err := spotify.refreshUserToken(); err != nil {
+			return "", err
+		}
+	}
+	return spotify.tokens.UserAccessToken, nil
+}
+
+// refreshUserToken exchanges the refresh token for a new user access token
+// and caches it. The caller must hold spotify.mu.
+func (spotify *Spotify) refreshUserToken() error {
+	unlock, err := spotify.lockTokens()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have refreshed the tokens while we waited.
+	stale := spotify.tokens.UserAccessToken
+	if err := spotify.loadSavedTokens(); err != nil {
+		return err
+	}
+	if spotify.tokens.UserAccessToken != stale && spotify.tokens.userTokenValid(time.Now()) {
+		return nil
+	}
+
+	if err := spotify.acquireTokens(spotify.tokens.UserRefreshToken, "refresh"); err != nil {
+		return err
+	}
+	return spotify.saveTokens()
+}
+
+// lockTokens keeps other processes from refreshing the saved tokens until
+// the returned function is called, if the token store supports it.
+// The caller must hold spotify.mu.
+func (spotify *Spotify) lockTokens() (unlock func(), err error) {
+	locker, ok := spotify.store.(TokenLocker)
+	if !ok {
+		return func() {}, nil
+	}
+	if unlock, err = locker.Lock(); err != nil {
+		return nil, fmt.Errorf("could not lock tokens: %w", err)
+	}
+	return unlock, nil
+}
+
+// loadSavedTokens loads the cached tokens (if there are any) into memory
+func (spotify *Spotify) loadSavedTokens() error {
+	data, err := spotify.store.Load()
+	if errors.Is(err, ErrNoTokens) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not load tokens: %w", err)
+	}
+	tokens := new(tokensT)
+	if err := json.Unmarshal(data, tokens); err != nil {
+		return fmt.Errorf("could not load tokens: %w", err)
+	}
+	spotify.tokens = tokens
+	return nil
+}
+
+// saveTokens saves the current tokens to the token store
+func (spotify *Spotify) saveTokens() error {
+	data, err := json.Marshal(spotify.tokens)
+	if err == nil {
+		err = spotify.store.Save(data)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save tokens: %w", err)
+	}
+	return nil
+}
+
+// acquireTokens exchanges AppClient or User AuthCode/Refresh tokens for
+// access tokens that can be used to make Spotify API calls.
+func (spotify *Spotify) acquireTokens(code string, tokenType string) error {
+	URL := spotify.opts.AccountsURL + "/api/token"
+	headers := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	}
+	form := url.Values{}
+	pkce := spotify.Config.UsesPKCE()
+	if pkce {
+		// Public clients identify themselves by ID only.
+		form.Set("client_id", spotify.Config.AppClientID)
+	} else {
+		appIdentity := []byte(spotify.Config.AppClientID + ":" + spotify.Config.AppClientSecret)
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString(appIdentity)
+	}
+
+	switch tokenType {
+	case "client":
+		if pkce {
+			return fmt.Errorf("app authorization requires AppClientSecret, which is not used with AuthFlow %q", AuthFlowPKCE)
+		}
+		form.Set("grant_type", "client_credentials")
+
+	case "auth":
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", code)
+		form.Set("redirect_uri", "http://localhost:"+spotify.Config.RedirectPort)
+		if pkce {
+			form.Set("code_verifier", spotify.auth.codeVerifier)
+		}
+
+	case "refresh":
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", code)
+		form.Set("redirect_uri", "http://localhost:"+spotify.Config.RedirectPort)
+
+	default:
+		return fmt.Errorf("bad value %q provided for tokenType arg to acquireTokens", tokenType)
+	}
+
+	// New tokens can be asked for again, but authorization codes only work once.
+	resp, err := spotify.request("Authorize", "POST", URL, headers, form.Encode(), tokenType != "auth")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("could not decode token response: %w", err)
+	}
+
+	// Spotify states the token lifetime in seconds, fall back to the
+	// documented lifetime of an hour if it does not.
+	lifetime := defaultTokenLifetime
+	if expiresIn, ok := payload["expires_in"].(float64); ok && expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	expiration := time.Now().Add(lifetime).Unix()
+	switch tokenType {
+	case "client":
+		if clientTok, ok := payload["access_token"].(string); ok {
+			spotify.tokens.AppAccessToken = clientTok
+			spotify.tokens.AppTokenExpiration = expiration
+		}
+	// Same logic otherwise
+	default:
+		if userTok, ok := payload["access_token"].(string); ok {
+			spotify.tokens.UserAccessToken = userTok
+			spotify.tokens.UserTokenExpiration = expiration
+		}
+		if refreshTok, ok := payload["refresh_token"].(string); ok {
+			spotify.tokens.UserRefreshToken = refreshTok
+		}
+		// Refreshes may leave out the scope, in which case it is unchanged.
+		if scope, ok := payload["scope"].(string); ok {
+			spotify.tokens.UserScopes = strings.Fields(scope)
+		}
+	}
+	return nil
+}
+
+// authorizeUser prompts the user to authorize his or her account for scopes
+// and waits until the authServer has received and extracted the authCode.
+func (spotify *Spotify) authorizeUser(scopes []string) (string, error) {
+	state, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	spotify.auth = &authT{state: state}
+
+	query := url.Values{}
+	query.Set("client_id", spotify.Config.AppClientID)
+	query.Set("response_type", "code")
+	query.Set("redirect_uri", "http://localhost:"+spotify.Config.RedirectPort)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+
+	if spotify.Config.UsesPKCE() {
+		verifier, err := newCodeVerifier()
+		if err != nil {
+			return "", err
+		}
+		spotify.auth.codeVerifier = verifier
+		query.Set("code_challenge_method", "S256")
+		query.Set("code_challenge", codeChallenge(verifier))
+	}
+	authURL := spotify.opts.AccountsURL + "/authorize?" + query.Encode()
+
+	if spotify.opts.NoBrowser {
+		return spotify.authorizeUserHeadless(authURL)
+	}
+
+	if err := spotify.startAuthServer(); err != nil {
+		return "", err
+	}
+	defer spotify.stopAuthServer()
+
+	fmt.Fprintf(spotify.opts.Stdout, "\nPlease navigate to this URL to Authorize Spotify:\n\n%s\n", authURL)
+	_ = utils.OpenInBrowser(authURL)
+	// Block while waiting for authorization code to be received
+	// by redirect handler
+	select {
+	case result := <-spotify.auth.results:
+		return result.code, result.err
+	case <-time.After(spotify.opts.LoginTimeout):
+		return "", fmt.Errorf("user authorization timed out after %v, please try again", spotify.opts.LoginTimeout)
+	}
+}
+
+// authorizeUserHeadless is authorizeUser for machines without a browser. The
+// user opens authURL on any other device and pastes back the URL Spotify
+// redirected to, which fails to load there since nothing listens on it.
+func (spotify *Spotify) authorizeUserHeadless(authURL string) (string, error) {
+	out := spotify.opts.Stdout
+	fmt.Fprintf(out, "\nOpen this URL in a browser on any device to Authorize Spotify:\n\n%s\n\n", authURL)
+	fmt.Fprintf(out, "Once approved, the browser is sent to http://localhost:%s which will not load.\n", spotify.Config.RedirectPort)
+	fmt.Fprintf(out, "Paste that URL from the address bar (or just its code) here: ")
+
+	line, err := bufio.NewReader(spotify.opts.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read the redirect URL: %w", err)
+	}
+	return parseAuthRedirect(strings.TrimSpace(line), spotify.auth.state)
+}
+
+// parseAuthRedirect extracts the authorization code from the redirect URL the
+// user pasted, verifying its state matches. A bare code is accepted as is.
+func parseAuthRedirect(input string, state string) (string, error) {
+	if input == "" {
+		return "", fmt.Errorf("user authorization failed: no redirect URL or code was provided")
+	}
+	if !strings.ContainsAny(input, "?=&") {
+		return input, nil
+	}
+
+	rawQuery := input
+	if i := strings.Index(input, "?"); i >= 0 {
+		rawQuery = input[i+1:]
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("could not parse the redirect URL: %w", err)
+	}
+	return authCodeFromRedirect(query, state)
+}
+
+// authCodeFromRedirect extracts the authorization code from the query of
+// Spotify's redirect, verifying its state matches.
+func authCodeFromRedirect(query url.Values, state string) (string, error) {
+	if reason := query.Get("error"); reason != "" {
+		return "", fmt.Errorf("user authorization failed: %s", reason)
+	}
+	if query.Get("state") != state {
+		return "", ErrStateMismatch
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("user authorization failed: the redirect URL has no code")
+	}
+	return code, nil
+}
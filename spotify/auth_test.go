@@ -0,0 +1,30 @@
+package spotify
+
+import "testing"
+
+var parseAuthRedirectTest = []struct {
+	name     string
+	input    string
+	expected string
+	hasError bool
+}{
+	{"Redirect URL", "http://localhost:5555/?code=AQBx&state=s3cr3t", "AQBx", false},
+	{"Query only", "code=AQBx&state=s3cr3t", "AQBx", false},
+	{"Bare code", "AQBx", "AQBx", false},
+	{"Wrong state", "http://localhost:5555/?code=AQBx&state=forged", "", true},
+	{"Missing state", "http://localhost:5555/?code=AQBx", "", true},
+	{"Access denied", "http://localhost:5555/?error=access_denied&state=s3cr3t", "", true},
+	{"Missing code", "http://localhost:5555/?state=s3cr3t", "", true},
+	{"Empty", "", "", true},
+}
+
+func TestParseAuthRedirect(t *testing.T) {
+	for _, tt := range parseAuthRedirectTest {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := parseAuthRedirect(tt.input, "s3cr3t")
+			if (err != nil) != tt.hasError || code != tt.expected {
+				t.Errorf("Got %q, %v but expected %q", code, err, tt.expected)
+			}
+		})
+	}
+}
@@ -1,27 +1,101 @@
 package spotify
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/charlesyu108/spotify-cli/utils"
 )
 
+// Values for ConfigT.AuthFlow
+const (
+	AuthFlowClientSecret = "client-secret" // Authorization Code flow, authenticated with AppClientSecret
+	AuthFlowPKCE         = "pkce"          // Authorization Code flow with PKCE, no AppClientSecret needed
+)
+
+// Values for ConfigT.TokenStorage
+const (
+	TokenStorageFile      = "file"      // Plain text file only its owner can read
+	TokenStorageEncrypted = "encrypted" // File encrypted with a passphrase or a key file
+	TokenStorageMemory    = "memory"    // Nothing is saved, every run logs in again
+)
+
+// Values for ConfigT.OutputFormat
+const (
+	OutputText = "text" // Human readable
+	OutputJSON = "json" // Machine readable, for scripts
+)
+
+// MarketFromToken is the ConfigT.Market of the logged in user's country.
+const MarketFromToken = "from_token"
+
+// Defaults of optional settings left empty.
+const (
+	DefaultVolumeStep     = 10
+	DefaultSearchLimit    = 5
+	DefaultRequestTimeout = 30 * time.Second
+)
+
 // ConfigT is the type for a Config
 type ConfigT struct {
-	AppClientID     string // Required
-	AppClientSecret string // Required
-	RedirectPort    string // Required
+	AppClientID     string   // Required
+	AppClientSecret string   // Required unless AuthFlow is "pkce"
+	RedirectPort    string   // Required, a port between 1 and 65535
+	AuthFlow        string   // Optional, one of { 'client-secret', 'pkce' }. Defaults to 'client-secret'
+	Scopes          []string `json:",omitempty"` // Optional, scopes requested on login on top of DefaultScopes
+	TokenStorage    string   `json:",omitempty"` // Optional, one of { 'file', 'encrypted', 'memory' }. Defaults to 'file'
+	DefaultDevice   string   `json:",omitempty"` // Optional, device to play on when none is active. Any partial identifier, as for FindDevice
+	VolumeStep      int      `json:",omitempty"` // Optional, percent to step the volume up or down by, 1-100. Defaults to 10
+	OutputFormat    string   `json:",omitempty"` // Optional, one of { 'text', 'json' }. Defaults to 'text'
+	Market          string   `json:",omitempty"` // Optional, ISO 3166-1 alpha-2 country code or 'from_token' search results must be playable in
+	SearchLimit     int      `json:",omitempty"` // Optional, how many search results to pick the best match from, 1-50. Defaults to 5
+	RequestTimeout  Duration `json:",omitempty"` // Optional, timeout for each request, i.e. '10s'. Defaults to 30s
+	LoginTimeout    Duration `json:",omitempty"` // Optional, how long users have to authorize the app. Defaults to 5m
+	MaxRetries      *int     `json:",omitempty"` // Optional, retries of failed requests, 0 to never retry. Defaults to 3
+	RetryMaxWait    Duration `json:",omitempty"` // Optional, upper bound for the total time waiting to retry a request. Defaults to 30s
+}
+
+// Duration is a time.Duration saved as text, i.e. "1m30s".
+type Duration time.Duration
+
+// String implements fmt.Stringer.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
 }
 
 // LoadConfig loads up the config
-// Returns a ConfigT and a boolean denoting if the config file was created.
-func LoadConfig(configFile string) (*ConfigT, bool) {
+// Returns a ConfigT and a boolean denoting if the config file is missing or
+// empty, in which case the config is empty too. Malformed config files are
+// an error rather than silently replaced.
+func LoadConfig(configFile string) (*ConfigT, bool, error) {
 	cfg := new(ConfigT)
 	err := utils.LoadJSON(configFile, cfg)
+	if errors.Is(err, utils.ErrFileNotFound) || errors.Is(err, utils.ErrFileEmpty) {
+		return cfg, true, nil
+	}
 	if err != nil {
-		return cfg, true
+		return cfg, false, err
 	}
-	return cfg, false
+	return cfg, false, nil
 }
 
 // Validate that the config is good.
@@ -29,16 +103,113 @@ func (c *ConfigT) Validate() error {
 	if c.AppClientID == "" {
 		return fmt.Errorf("AppClientID must not be empty")
 	}
-	if c.AppClientSecret == "" {
-		return fmt.Errorf("AppClientSecret must not be empty")
+	if c.AppClientSecret == "" && !c.UsesPKCE() {
+		return fmt.Errorf("AppClientSecret must not be empty unless AuthFlow is '%s'", AuthFlowPKCE)
 	}
 	if c.RedirectPort == "" {
 		return fmt.Errorf("Redirect must not be empty")
 	}
+	fields := reflect.TypeOf(*c)
+	for i := 0; i < fields.NumField(); i++ {
+		if err := c.ValidateSetting(fields.Field(i).Name); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ValidateSetting validates the value of a single setting, named as its
+// field. Empty values are valid, Validate checks the required ones are set.
+func (c *ConfigT) ValidateSetting(key string) error {
+	if check, ok := settingChecks[key]; ok {
+		return check(c)
+	}
+	return nil
+}
+
+// settingChecks validate the values of the settings that are restricted.
+var settingChecks = map[string]func(c *ConfigT) error{
+	"RedirectPort": func(c *ConfigT) error {
+		if port, err := strconv.Atoi(c.RedirectPort); c.RedirectPort != "" && (err != nil || port < 1 || port > 65535) {
+			return fmt.Errorf("RedirectPort must be a port between 1 and 65535, not '%s'", c.RedirectPort)
+		}
+		return nil
+	},
+	"AuthFlow": func(c *ConfigT) error {
+		return oneOf("AuthFlow", c.AuthFlow, AuthFlowClientSecret, AuthFlowPKCE)
+	},
+	"TokenStorage": func(c *ConfigT) error {
+		return oneOf("TokenStorage", c.TokenStorage, TokenStorageFile, TokenStorageEncrypted, TokenStorageMemory)
+	},
+	"VolumeStep": func(c *ConfigT) error {
+		return inRange("VolumeStep", c.VolumeStep, 1, 100)
+	},
+	"OutputFormat": func(c *ConfigT) error {
+		return oneOf("OutputFormat", c.OutputFormat, OutputText, OutputJSON)
+	},
+	"Market": func(c *ConfigT) error {
+		if c.Market != "" && c.Market != MarketFromToken && !isCountryCode(c.Market) {
+			return fmt.Errorf("Market must be a two letter country code like 'US' or '%s', not '%s'", MarketFromToken, c.Market)
+		}
+		return nil
+	},
+	"SearchLimit": func(c *ConfigT) error {
+		return inRange("SearchLimit", c.SearchLimit, 1, 50)
+	},
+	"RequestTimeout": func(c *ConfigT) error {
+		return notNegative("RequestTimeout", c.RequestTimeout)
+	},
+	"LoginTimeout": func(c *ConfigT) error {
+		return notNegative("LoginTimeout", c.LoginTimeout)
+	},
+	"MaxRetries": func(c *ConfigT) error {
+		if c.MaxRetries != nil && *c.MaxRetries < 0 {
+			return fmt.Errorf("MaxRetries must not be negative, not %d", *c.MaxRetries)
+		}
+		return nil
+	},
+	"RetryMaxWait": func(c *ConfigT) error {
+		return notNegative("RetryMaxWait", c.RetryMaxWait)
+	},
+}
+
+func oneOf(key string, value string, allowed ...string) error {
+	if value == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of '%s', not '%s'", key, strings.Join(allowed, "', '"), value)
+}
+
+// inRange checks an optional number, zero stands for its default.
+func inRange(key string, value int, min int, max int) error {
+	if value != 0 && (value < min || value > max) {
+		return fmt.Errorf("%s must be between %d and %d, not %d", key, min, max, value)
+	}
+	return nil
+}
+
+func notNegative(key string, d Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%s must not be negative, not %s", key, d)
+	}
+	return nil
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// UsesPKCE reports whether users are authorized with PKCE instead of the client secret.
+func (c *ConfigT) UsesPKCE() bool {
+	return c.AuthFlow == AuthFlowPKCE
+}
+
 // SaveConfig saves the Config defined by c to file.
-func SaveConfig(c *ConfigT, configFile string) {
-	utils.SaveJSON(configFile, c)
+func SaveConfig(c *ConfigT, configFile string) error {
+	return utils.SaveJSON(configFile, c)
 }
@@ -1,8 +1,14 @@
 package spotify
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/utils"
 )
 
 var validateTest = []struct {
@@ -13,10 +19,26 @@ var validateTest = []struct {
 	{"No fields", &ConfigT{}, true},
 	{"AppClientID Only", &ConfigT{AppClientID: "test123"}, true},
 	{"AppClientSecret Only", &ConfigT{AppClientSecret: "test123"}, true},
-	{"RedirectPort Only", &ConfigT{RedirectPort: "test123"}, true},
-	{"RedirectPort and AppClientSecret ", &ConfigT{RedirectPort: "test123", AppClientSecret: "test123"}, true},
+	{"RedirectPort Only", &ConfigT{RedirectPort: "5555"}, true},
+	{"RedirectPort and AppClientSecret ", &ConfigT{RedirectPort: "5555", AppClientSecret: "test123"}, true},
 	{"AppClientID and AppClientSecret ", &ConfigT{AppClientID: "test123", AppClientSecret: "test123"}, true},
-	{"Valid", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "test123"}, false},
+	{"Valid", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555"}, false},
+	{"RedirectPort not a number", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "test123"}, true},
+	{"RedirectPort out of range", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "65536"}, true},
+	{"RedirectPort zero", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "0"}, true},
+	{"Valid client-secret AuthFlow", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", AuthFlow: "client-secret"}, false},
+	{"PKCE without AppClientSecret", &ConfigT{AppClientID: "test123", RedirectPort: "5555", AuthFlow: "pkce"}, false},
+	{"PKCE without AppClientID", &ConfigT{RedirectPort: "5555", AuthFlow: "pkce"}, true},
+	{"Encrypted TokenStorage", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", TokenStorage: "encrypted"}, false},
+	{"Unknown TokenStorage", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", TokenStorage: "keychain"}, true},
+	{"Unknown AuthFlow", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", AuthFlow: "implicit"}, true},
+	{"All optional settings", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", DefaultDevice: "mbp", VolumeStep: 5, OutputFormat: "json", Market: "US", SearchLimit: 50, RequestTimeout: Duration(10 * time.Second), MaxRetries: new(int)}, false},
+	{"VolumeStep out of range", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", VolumeStep: 101}, true},
+	{"Unknown OutputFormat", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", OutputFormat: "yaml"}, true},
+	{"Market from token", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", Market: "from_token"}, false},
+	{"Lowercase Market", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", Market: "us"}, true},
+	{"SearchLimit out of range", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", SearchLimit: 51}, true},
+	{"Negative RequestTimeout", &ConfigT{AppClientID: "test123", AppClientSecret: "test123", RedirectPort: "5555", RequestTimeout: Duration(-time.Second)}, true},
 }
 
 func TestValidateConfig(t *testing.T) {
@@ -36,11 +58,11 @@ func TestLoadConfig(t *testing.T) {
 	t.Run("When proper file exists", func(t *testing.T) {
 		file := ".tmp"
 		fptr, _ := os.Create(file)
-		fptr.WriteString(`{"AppClientID": "test123", "AppClientSecret": "test123", "RedirectPort": "test123"}\n`)
+		fptr.WriteString(`{"AppClientID": "test123", "AppClientSecret": "test123", "RedirectPort": "5555", "RequestTimeout": "10s"}\n`)
 		fptr.Close()
 
-		cfg, created := LoadConfig(file)
-		if created || cfg.Validate() != nil {
+		cfg, missing, err := LoadConfig(file)
+		if err != nil || missing || cfg.Validate() != nil || cfg.RequestTimeout != Duration(10*time.Second) {
 			t.FailNow()
 		}
 		t.Cleanup(func() {
@@ -50,17 +72,31 @@ func TestLoadConfig(t *testing.T) {
 
 	t.Run("When file does not exist", func(t *testing.T) {
 		file := ".tmpasdf123"
-		cfg, created := LoadConfig(file)
-		// A file should be created and an empty config is loaded
-		if !created || (*cfg != ConfigT{}) {
+		cfg, missing, err := LoadConfig(file)
+		// An empty config is loaded, without creating the file
+		if err != nil || !missing || !reflect.DeepEqual(*cfg, ConfigT{}) {
 			t.FailNow()
 		}
-		t.Cleanup(func() {
-			os.Remove(file)
-		})
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected no file to be created but got %v", err)
+		}
 	})
 }
 
+func TestLoadMalformedConfig(t *testing.T) {
+	file := ".tmpmalformed"
+	if err := ioutil.WriteFile(file, []byte(`{"AppClientID": "test1`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(file)
+	})
+
+	if _, created, err := LoadConfig(file); created || !errors.Is(err, utils.ErrFileMalformed) {
+		t.Errorf("Expected ErrFileMalformed but got %v (created: %v)", err, created)
+	}
+}
+
 func TestSaveConfig(t *testing.T) {
 
 	t.Run("Saves config to a file that didn't exist", func(t *testing.T) {
@@ -0,0 +1,90 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrNoDevices is returned when an operation needs a playback device
+// but Spotify does not report any.
+var ErrNoDevices = errors.New("no playable devices found, is Spotify open on any of your devices?")
+
+// ErrNoActiveDevice is returned when an operation needs the active device
+// but nothing is playing or paused on any device.
+var ErrNoActiveDevice = errors.New("no active device, play something first")
+
+// ErrDeviceNotFound is returned when no device matches a search.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrNoResults is returned when a search does not match anything.
+var ErrNoResults = errors.New("no results found")
+
+// ErrStateMismatch is returned when the state of an authorization redirect
+// does not match the one sent, which means it was not requested by us.
+var ErrStateMismatch = errors.New("user authorization failed: the state parameter of the redirect does not match, please try again")
+
+// APIError describes a non-successful response from the Spotify Web API
+// or the Spotify Accounts service.
+type APIError struct {
+	Operation  string        // The Spotify method that made the request, i.e. "Play"
+	StatusCode int           // HTTP status code of the response
+	Message    string        // Spotify's error message, if one was provided
+	Reason     string        // Spotify's player error reason, i.e. "NO_ACTIVE_DEVICE"
+	Body       []byte        // Raw response body
+	RetryAfter time.Duration // How long Spotify asked to wait before retrying, if it did
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s operation failed with %d %s", e.Operation, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	switch e.StatusCode {
+	case http.StatusForbidden:
+		msg += ". Is this operation allowed right now?"
+	case http.StatusNotFound:
+		msg += ". Are there active devices?"
+	}
+	return msg
+}
+
+// checkResponse returns an *APIError describing r if its status code does
+// not indicate success. The response body is consumed in that case.
+func checkResponse(operation string, r *http.Response) error {
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	apiErr := &APIError{Operation: operation, StatusCode: r.StatusCode, Body: body}
+	if seconds, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	// The Web API nests its error object while the Accounts service
+	// uses the OAuth 2.0 "error" / "error_description" pair.
+	var webErr struct {
+		Error struct {
+			Message string `json:"message"`
+			Reason  string `json:"reason"`
+		} `json:"error"`
+	}
+	var authErr struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &webErr) == nil {
+		apiErr.Message, apiErr.Reason = webErr.Error.Message, webErr.Error.Reason
+	} else if json.Unmarshal(body, &authErr) == nil {
+		apiErr.Message, apiErr.Reason = authErr.Description, authErr.Error
+	}
+	return apiErr
+}
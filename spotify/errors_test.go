@@ -0,0 +1,51 @@
+package spotify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var checkResponseTest = []struct {
+	name        string
+	status      int
+	body        string
+	expectError bool
+	message     string
+	reason      string
+}{
+	{"No Content", 204, ``, false, "", ""},
+	{"OK", 200, `{"devices": []}`, false, "", ""},
+	{"Web API error", 404, `{"error": {"status": 404, "message": "Player command failed: No active device found", "reason": "NO_ACTIVE_DEVICE"}}`, true, "Player command failed: No active device found", "NO_ACTIVE_DEVICE"},
+	{"Accounts error", 400, `{"error": "invalid_client", "error_description": "Invalid client secret"}`, true, "Invalid client secret", "invalid_client"},
+	{"Unparsable body", 502, `<html>Bad Gateway</html>`, true, "", ""},
+}
+
+func TestCheckResponse(t *testing.T) {
+	for _, tt := range checkResponseTest {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+			err := checkResponse("Test", resp)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Error Returned? %v but Expected %v", err != nil, tt.expectError)
+			}
+			if err == nil {
+				return
+			}
+			apiErr, ok := err.(*APIError)
+			if !ok {
+				t.Fatalf("Expected *APIError but got %T", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Operation != "Test" {
+				t.Errorf("Got status %d for operation %q", apiErr.StatusCode, apiErr.Operation)
+			}
+			if apiErr.Message != tt.message || apiErr.Reason != tt.reason {
+				t.Errorf("Got message %q and reason %q", apiErr.Message, apiErr.Reason)
+			}
+			if string(apiErr.Body) != tt.body {
+				t.Errorf("Got body %q", apiErr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package spotify
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default endpoints of the Spotify services.
+const (
+	DefaultAPIURL      = "https://api.spotify.com/v1"
+	DefaultAccountsURL = "https://accounts.spotify.com"
+)
+
+// DefaultLoginTimeout is how long users have to authorize the app in the browser.
+const DefaultLoginTimeout = 5 * time.Minute
+
+// DefaultUserAgent is sent with every request unless Options.UserAgent is set.
+const DefaultUserAgent = "spotify-cli"
+
+// Options configures how a Spotify reaches the Spotify services.
+// The zero value talks to the public Spotify endpoints.
+type Options struct {
+	APIURL       string        // Base URL of the Web API. Defaults to DefaultAPIURL
+	AccountsURL  string        // Base URL of the Accounts service. Defaults to DefaultAccountsURL
+	HTTPClient   *http.Client  // Client used for every request. Defaults to a new http.Client
+	UserAgent    string        // User-Agent header. Defaults to DefaultUserAgent
+	Timeout      time.Duration // Timeout for each request. Zero keeps the client's own timeout
+	TokenFile    string        // File the tokens are cached in, unless TokenStore is set. Defaults to .tokens in utils.GetStateDir()
+	TokenStore   TokenStore    // Where the tokens are cached. Defaults to a FileTokenStore of TokenFile
+	Retry        *RetryPolicy  // How failed requests are retried. Defaults to DefaultRetryPolicy
+	NoBrowser    bool          // Authorize users by pasting the redirect URL instead of opening a browser
+	LoginTimeout time.Duration // How long to wait for the user to authorize. Defaults to DefaultLoginTimeout
+	Stdin        io.Reader     // Where pasted redirect URLs are read from. Defaults to os.Stdin
+	Stdout       io.Writer     // Where authorization prompts are written to. Defaults to os.Stdout
+}
+
+// RetryPolicy controls how failed requests are retried. Requests are retried
+// when rate limited with 429 Too Many Requests and, unless they are POSTs that
+// might have taken effect already, on 5xx responses and network errors.
+// Refreshing tokens and authorizing the app are POSTs safe to retry.
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt. Zero disables retrying
+	BaseDelay  time.Duration // Delay before the first retry, doubled for each one after
+	MaxDelay   time.Duration // Upper bound for a single delay
+	MaxWait    time.Duration // Upper bound for the total time spent waiting between attempts
+}
+
+// DefaultRetryPolicy is used when Options.Retry is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   10 * time.Second,
+	MaxWait:    30 * time.Second,
+}
+
+// withDefaults returns a copy of opts with all empty fields filled in.
+func (opts Options) withDefaults() Options {
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+	if opts.AccountsURL == "" {
+		opts.AccountsURL = DefaultAccountsURL
+	}
+	opts.APIURL = strings.TrimRight(opts.APIURL, "/")
+	opts.AccountsURL = strings.TrimRight(opts.AccountsURL, "/")
+
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	if opts.LoginTimeout <= 0 {
+		opts.LoginTimeout = DefaultLoginTimeout
+	}
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+
+	if opts.Retry == nil {
+		policy := DefaultRetryPolicy
+		opts.Retry = &policy
+	}
+
+	// Never modify a client owned by the caller.
+	client := new(http.Client)
+	if opts.HTTPClient != nil {
+		*client = *opts.HTTPClient
+	}
+	if opts.Timeout > 0 {
+		client.Timeout = opts.Timeout
+	}
+	opts.HTTPClient = client
+	return opts
+}
@@ -0,0 +1,29 @@
+package spotify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// randomToken returns n random bytes encoded as unpadded base64url.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newCodeVerifier returns a random PKCE code verifier as defined by RFC 7636.
+func newCodeVerifier() (string, error) {
+	// 64 random bytes encode to 86 characters, within the allowed 43 to 128.
+	return randomToken(64)
+}
+
+// codeChallenge returns the S256 code challenge for verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,26 @@
+package spotify
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCodeVerifier(t *testing.T) {
+	// RFC 7636 allows 43 to 128 characters of [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~"
+	valid := regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	first, err := newCodeVerifier()
+	if err != nil || !valid.MatchString(first) {
+		t.Errorf("Invalid code verifier %q, %v", first, err)
+	}
+	if second, _ := newCodeVerifier(); second == first {
+		t.Errorf("Expected code verifiers to be random")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// S256 is BASE64URL(SHA256(verifier)) without padding
+	verifier := "dBjftJeZ4CVP-mJ92K9DIFHXN5pkW1x8oUUKEkYDd3Vv3BgcBkMRlaWHWlIpEM4tdQSD"
+	if challenge := codeChallenge(verifier); challenge != "a_cSX3JpXSKhw0_1o4KRskpf8QrVPDq5p8Cx-y7ly2s" {
+		t.Errorf("Got code challenge %q", challenge)
+	}
+}
@@ -0,0 +1,100 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+)
+
+// authT defines a struct that encapsulates all resources
+// required to obtain Authorization credentials
+type authT struct {
+	results      chan authResult // Receives the outcome of the first redirect
+	server       *http.Server
+	state        string // Random value that must come back with the redirect
+	codeVerifier string // PKCE code verifier, only used with AuthFlowPKCE
+}
+
+// authResult is the outcome of an authorization redirect.
+type authResult struct {
+	code string
+	err  error
+}
+
+// startAuthServer starts the local server that receives the authorization
+// redirect from Spotify. It only listens on the loopback interface, so that
+// nobody else on the network can reach it, and fails right away if the
+// redirect port is taken.
+func (spotify *Spotify) startAuthServer() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", spotify.Config.RedirectPort))
+	if err != nil {
+		return fmt.Errorf("could not listen for the authorization redirect on port %s: %w", spotify.Config.RedirectPort, err)
+	}
+
+	mux := http.NewServeMux()
+	// Register Auth Redirect Handler
+	mux.HandleFunc("/", spotify.handleAuthorizeUserRedirect)
+	spotify.auth.results = make(chan authResult, 1)
+	spotify.auth.server = &http.Server{Handler: mux}
+	// Start auth server
+	go spotify.auth.server.Serve(listener)
+	return nil
+}
+
+// stopAuthServer gracefully shuts down the server started by startAuthServer,
+// giving it a moment to finish sending the result page.
+func (spotify *Spotify) stopAuthServer() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	spotify.auth.server.Shutdown(ctx)
+}
+
+// handleAuthorizeUserRedirect is the HTTP Handler that listens for activity on the
+// local authorization server & extracts the obtained user access token for OAuth.
+func (spotify *Spotify) handleAuthorizeUserRedirect(w http.ResponseWriter, req *http.Request) {
+	// Browsers also ask for things like /favicon.ico
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	code, err := authCodeFromRedirect(req.URL.Query(), spotify.auth.state)
+	select {
+	case spotify.auth.results <- authResult{code, err}:
+	default:
+		// Only the first redirect counts, i.e. when the page is reloaded.
+		err = fmt.Errorf("this login attempt is already complete, you can close this window")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page := struct {
+		Success bool
+		Message string
+	}{Success: err == nil, Message: "You are logged in to spotify-cli. You can close this window."}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		page.Message = err.Error()
+	}
+	redirectPage.Execute(w, page)
+}
+
+// redirectPage is shown in the browser once Spotify redirected back.
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>spotify-cli</title>
+	<style>
+		body { font-family: sans-serif; text-align: center; margin-top: 15%; background: #191414; color: #fff; }
+		h1 { color: {{if .Success}}#1db954{{else}}#e22134{{end}}; }
+	</style>
+</head>
+<body>
+	<h1>{{if .Success}}Success!{{else}}User Authorization failed{{end}}</h1>
+	<p>{{.Message}}</p>
+</body>
+</html>
+`))
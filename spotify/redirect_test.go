@@ -0,0 +1,123 @@
+package spotify
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freePort returns a TCP port that nothing listens on.
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// visit requests path on the local auth server and returns the status and page.
+func visit(t *testing.T, port string, path string) (int, string) {
+	resp, err := http.Get("http://localhost:" + port + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(page)
+}
+
+func TestAuthServer(t *testing.T) {
+	t.Run("Delivers the code of the first redirect", func(t *testing.T) {
+		port := freePort(t)
+		spotify := New(&ConfigT{RedirectPort: port}, Options{})
+		spotify.auth = &authT{state: "s3cr3t"}
+		if err := spotify.startAuthServer(); err != nil {
+			t.Fatal(err)
+		}
+
+		if status, _ := visit(t, port, "/favicon.ico"); status != http.StatusNotFound {
+			t.Errorf("Expected 404 for other paths but got %d", status)
+		}
+		if status, page := visit(t, port, "/?code=AQBx&state=s3cr3t"); status != http.StatusOK || !strings.Contains(page, "Success!") {
+			t.Errorf("Expected success page but got %d: %s", status, page)
+		}
+		if status, page := visit(t, port, "/?code=AQBx&state=s3cr3t"); status != http.StatusBadRequest || !strings.Contains(page, "already complete") {
+			t.Errorf("Expected reloads to be rejected but got %d: %s", status, page)
+		}
+		if result := <-spotify.auth.results; result.code != "AQBx" || result.err != nil {
+			t.Errorf("Expected code AQBx but got %+v", result)
+		}
+
+		spotify.stopAuthServer()
+		listener, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			t.Fatalf("Expected port to be released after shutdown but got %v", err)
+		}
+		listener.Close()
+	})
+
+	t.Run("Rejects forged state", func(t *testing.T) {
+		port := freePort(t)
+		spotify := New(&ConfigT{RedirectPort: port}, Options{})
+		spotify.auth = &authT{state: "s3cr3t"}
+		if err := spotify.startAuthServer(); err != nil {
+			t.Fatal(err)
+		}
+		defer spotify.stopAuthServer()
+
+		if status, page := visit(t, port, "/?code=AQBx&state=forged"); status != http.StatusBadRequest || !strings.Contains(page, "failed") {
+			t.Errorf("Expected failure page but got %d: %s", status, page)
+		}
+		if result := <-spotify.auth.results; !errors.Is(result.err, ErrStateMismatch) {
+			t.Errorf("Expected ErrStateMismatch but got %+v", result)
+		}
+	})
+
+	t.Run("Only listens on the loopback interface", func(t *testing.T) {
+		var external net.IP
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if ip, ok := addr.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+				external = ip.IP
+				break
+			}
+		}
+		if external == nil {
+			t.Skip("No network interface besides the loopback one")
+		}
+
+		port := freePort(t)
+		spotify := New(&ConfigT{RedirectPort: port}, Options{})
+		spotify.auth = &authT{state: "s3cr3t"}
+		if err := spotify.startAuthServer(); err != nil {
+			t.Fatal(err)
+		}
+		defer spotify.stopAuthServer()
+
+		if conn, err := net.DialTimeout("tcp", net.JoinHostPort(external.String(), port), time.Second); err == nil {
+			conn.Close()
+			t.Errorf("Expected the redirect not to be reachable on %s", external)
+		}
+	})
+
+	t.Run("Fails when the port is taken", func(t *testing.T) {
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+		spotify := New(&ConfigT{RedirectPort: port}, Options{})
+		spotify.auth = &authT{state: "s3cr3t"}
+		if err := spotify.startAuthServer(); err == nil {
+			t.Errorf("Expected an error for a port in use")
+		}
+	})
+}
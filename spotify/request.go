@@ -0,0 +1,159 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+)
+
+// do performs an HTTP request on behalf of operation, retrying it according
+// to the retry policy. It returns the response if its status code indicates
+// success and an *APIError otherwise. POST requests might have taken effect
+// before failing, so they are only retried when rate limited.
+// The caller is responsible for closing the response body.
+func (spotify *Spotify) do(operation, method, URL string, headers map[string]string, body string) (*http.Response, error) {
+	return spotify.request(operation, method, URL, headers, body, method != "POST")
+}
+
+// request is do for requests that are safe to repeat even if they took
+// effect already, if idempotent is set.
+func (spotify *Spotify) request(operation, method, URL string, headers map[string]string, body string, idempotent bool) (*http.Response, error) {
+	withAgent := map[string]string{"User-Agent": spotify.opts.UserAgent}
+	for k, v := range headers {
+		withAgent[k] = v
+	}
+
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		resp, err := spotify.doOnce(operation, method, URL, withAgent, body)
+		delay, retry := spotify.opts.Retry.delay(attempt, idempotent, err)
+		if !retry || waited+delay > spotify.opts.Retry.MaxWait {
+			return resp, err
+		}
+		time.Sleep(delay)
+		waited += delay
+	}
+}
+
+// doOnce performs a single attempt of the request made by do.
+func (spotify *Spotify) doOnce(operation, method, URL string, headers map[string]string, body string) (*http.Response, error) {
+	resp, err := utils.MakeHTTPRequest(spotify.opts.HTTPClient, method, URL, headers, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s operation failed: %w", operation, err)
+	}
+	if err := checkResponse(operation, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// delay returns how long to wait before retrying a request that failed with
+// err on the given zero-based attempt, and whether it should be retried at all.
+// Requests that are not idempotent are only retried when rate limited.
+func (p *RetryPolicy) delay(attempt int, idempotent bool, err error) (time.Duration, bool) {
+	if err == nil || attempt >= p.MaxRetries {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	isAPIErr := errors.As(err, &apiErr)
+	switch {
+	case isAPIErr && apiErr.StatusCode == http.StatusTooManyRequests:
+		// Rate limited requests were not processed, so they are always safe to retry.
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter, true
+		}
+	case !idempotent:
+		return 0, false
+	case isAPIErr && apiErr.StatusCode < 500:
+		return 0, false
+	}
+	return p.backoff(attempt), true
+}
+
+// backoff returns an exponentially growing delay for the given zero-based
+// attempt. Half of it is random jitter, so that concurrent clients spread out.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// api is like do, but authenticates the request with the user access token.
+// If the Web API rejects the token it is refreshed and the request retried once.
+// Requests rejected for lack of scopes return a *ScopeError.
+func (spotify *Spotify) api(operation, method, URL string, headers map[string]string, body string) (*http.Response, error) {
+	token, err := spotify.userToken(operation)
+	if err != nil {
+		return nil, err
+	}
+	withToken := func(token string) map[string]string {
+		h := map[string]string{"Authorization": "Bearer " + token}
+		for k, v := range headers {
+			h[k] = v
+		}
+		return h
+	}
+
+	resp, err := spotify.do(operation, method, URL, withToken(token), body)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return resp, scopeError(operation, err)
+	}
+	if token, err = spotify.renewUserToken(token); err != nil {
+		return nil, err
+	}
+	resp, err = spotify.do(operation, method, URL, withToken(token), body)
+	return resp, scopeError(operation, err)
+}
+
+// scopeError turns the Web API rejecting a request of operation for lack of
+// scopes into a *ScopeError. Other errors are returned unchanged.
+func scopeError(operation string, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden ||
+		!strings.Contains(strings.ToLower(apiErr.Message), "scope") {
+		return err
+	}
+	missing := operationScopes[operation]
+	if len(missing) == 0 {
+		missing = privateScopes[operation]
+	}
+	return &ScopeError{Operation: operation, Missing: missing, Err: err}
+}
+
+// send is like api, but discards the response body.
+func (spotify *Spotify) send(operation, method, URL string, headers map[string]string, body string) error {
+	resp, err := spotify.api(operation, method, URL, headers, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// decode decodes the JSON body of a successful response into v. Empty bodies,
+// which Spotify returns with 204 No Content, leave v untouched.
+func decode(operation string, resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("%s operation returned an unreadable response: %w", operation, err)
+	}
+	return nil
+}
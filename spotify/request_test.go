@@ -0,0 +1,18 @@
+package spotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		expected *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d < expected/2 || d > expected {
+				t.Fatalf("Attempt %d: expected delay in [%v, %v] but got %v", attempt, expected/2, expected, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package spotify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes users can grant spotify-cli. See
+// https://developer.spotify.com/documentation/general/guides/scopes/
+const (
+	ScopeUserReadPlaybackState    = "user-read-playback-state"
+	ScopeUserModifyPlaybackState  = "user-modify-playback-state"
+	ScopeUserReadCurrentlyPlaying = "user-read-currently-playing"
+	ScopeUserLibraryRead          = "user-library-read"
+	ScopeUserLibraryModify        = "user-library-modify"
+	ScopePlaylistReadPrivate      = "playlist-read-private"
+	ScopePlaylistModifyPrivate    = "playlist-modify-private"
+	ScopePlaylistModifyPublic     = "playlist-modify-public"
+	ScopeUserReadRecentlyPlayed   = "user-read-recently-played"
+	ScopeUserTopRead              = "user-top-read"
+)
+
+// DefaultScopes are requested on every login. They cover playback and saving
+// tracks, other scopes are only requested once a feature needs them.
+var DefaultScopes = []string{
+	ScopeUserReadPlaybackState,
+	ScopeUserModifyPlaybackState,
+	ScopeUserReadCurrentlyPlaying,
+	ScopeUserLibraryModify,
+}
+
+// operationScopes declares the scopes each operation needs. Every operation
+// is listed, those reading the public catalog need none.
+var operationScopes = map[string][]string{
+	"Play":           {ScopeUserModifyPlaybackState},
+	"PlayOnDevice":   {ScopeUserModifyPlaybackState},
+	"PlayURI":        {ScopeUserModifyPlaybackState},
+	"Pause":          {ScopeUserModifyPlaybackState},
+	"NextTrack":      {ScopeUserModifyPlaybackState},
+	"PreviousTrack":  {ScopeUserModifyPlaybackState},
+	"Volume":         {ScopeUserModifyPlaybackState},
+	"VolumeOnDevice": {ScopeUserModifyPlaybackState},
+	"Seek":           {ScopeUserModifyPlaybackState},
+	"Shuffle":        {ScopeUserModifyPlaybackState},
+	"Repeat":         {ScopeUserModifyPlaybackState},
+	"AddToQueue":     {ScopeUserModifyPlaybackState},
+	"Queue":          {ScopeUserReadPlaybackState},
+	"GetDevices":     {ScopeUserReadPlaybackState},
+	"CurrentState":   {ScopeUserReadPlaybackState},
+	"SaveTrack":      {ScopeUserLibraryModify},
+	"AlbumTracks":    nil,
+	"PlaylistTracks": nil,
+	"SimpleSearch":   nil,
+	"CurrentUser":    nil,
+}
+
+// privateScopes are scopes operations only need for private items, i.e. the
+// tracks of a private playlist. They are not checked before sending, but
+// asked for once the Web API rejects a request for lack of them.
+var privateScopes = map[string][]string{
+	"PlaylistTracks": {ScopePlaylistReadPrivate},
+}
+
+// ScopeError is returned when an operation needs scopes the user did not
+// grant. Logging in with the missing scopes fixes it, see Spotify.Login.
+type ScopeError struct {
+	Operation string   // The Spotify method that needs the scopes, i.e. "SaveTrack"
+	Missing   []string // Scopes that were not granted, empty if unknown
+	Err       error    // The rejected request, nil if it was not sent
+}
+
+// Error implements the error interface.
+func (e *ScopeError) Error() string {
+	if len(e.Missing) == 0 {
+		return fmt.Sprintf("%s operation needs permissions that were not granted", e.Operation)
+	}
+	return fmt.Sprintf("%s operation needs permissions that were not granted: %s", e.Operation, strings.Join(e.Missing, " "))
+}
+
+// Unwrap returns the error of the rejected request.
+func (e *ScopeError) Unwrap() error {
+	return e.Err
+}
+
+// CheckScopes returns a *ScopeError if the user did not grant the scopes one
+// of operations needs, so that actions making several changes can find out
+// before making the first.
+func (spotify *Spotify) CheckScopes(operations ...string) error {
+	spotify.mu.Lock()
+	defer spotify.mu.Unlock()
+	for _, operation := range operations {
+		if missing := spotify.missingScopes(operation); len(missing) > 0 {
+			return &ScopeError{Operation: operation, Missing: missing}
+		}
+	}
+	return nil
+}
+
+// missingScopes returns the scopes operation needs which the user did not
+// grant. Nothing is reported missing for tokens cached before scopes were
+// recorded, those are caught when the Web API rejects the request instead.
+// The caller must hold spotify.mu.
+func (spotify *Spotify) missingScopes(operation string) []string {
+	granted := spotify.tokens.UserScopes
+	if len(granted) == 0 {
+		return nil
+	}
+	var missing []string
+	for _, scope := range operationScopes[operation] {
+		if !containsScope(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// requestedScopes returns the scopes to request when the user logs in: the
+// default and configured scopes, those granted already and extra, without
+// duplicates. The caller must hold spotify.mu.
+func (spotify *Spotify) requestedScopes(extra []string) []string {
+	var scopes []string
+	for _, list := range [][]string{DefaultScopes, spotify.Config.Scopes, spotify.tokens.UserScopes, extra} {
+		for _, scope := range list {
+			if scope != "" && !containsScope(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package spotify
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestOperationScopes checks that every operation sent to the Web API
+// declares its scopes, so missing ones are caught before sending.
+func TestOperationScopes(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", nil, 0)
+	if err != nil {
+		t.Fatalf("Could not parse the package: %v", err)
+	}
+
+	operations := 0
+	for name, file := range pkgs["spotify"].Files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "api" && sel.Sel.Name != "send") {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			operation, _ := strconv.Unquote(lit.Value)
+			operations++
+			if _, ok := operationScopes[operation]; !ok {
+				t.Errorf("%v: operation %s does not declare its scopes", fset.Position(lit.Pos()), operation)
+			}
+			return true
+		})
+	}
+	if operations == 0 {
+		t.Error("Expected to find operations but found none")
+	}
+}
@@ -1,342 +1,315 @@
 package spotify
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charlesyu108/spotify-cli/utils"
 )
 
-// tokensT defines tokens and related info
-// for interfacing with the Spotify API
-type tokensT struct {
-	AppAccessToken      string
-	UserAccessToken     string
-	UserRefreshToken    string
-	UserTokenExpiration int64
-	AppTokenExpiration  int64
-}
-
-// authT defines a struct that encapsulates all resources
-// required to obtain Authorization credentials
-type authT struct {
-	codeChan chan string
-	server   *http.Server
-}
-
 // Spotify represents an interface to the Spotify API
 type Spotify struct {
-	Config    *ConfigT
-	tokens    *tokensT
-	tokenFile string
-	auth      *authT
+	Config *ConfigT
+	opts   Options
+	mu     sync.Mutex // Guards tokens
+	tokens *tokensT
+	store  TokenStore
+	auth   *authT
 }
 
 // New produces creates and initializes a new Spotify
-func New(cfg *ConfigT) Spotify {
-	spotify := Spotify{Config: cfg, tokens: new(tokensT)}
-	spotify.tokenFile = filepath.Join(utils.GetProgFilesDir(), ".tokens")
-	spotify.auth = &authT{
-		codeChan: make(chan string),
-		server: &http.Server{
-			Addr:    ":" + spotify.Config.RedirectPort,
-			Handler: http.DefaultServeMux,
-		},
-	}
-	// Register Auth Redirect Handler
-	http.HandleFunc("/", spotify.handleAuthorizeUserRedirect)
-	// Start auth server
-	go spotify.auth.server.ListenAndServe()
-
-	return spotify
-}
-
-// handleAuthorizeUserRedirect is the HTTP Handler that listens for activity on the
-// local authorization server & extracts the obtained user access token for OAuth.
-func (spotify *Spotify) handleAuthorizeUserRedirect(w http.ResponseWriter, req *http.Request) {
-	userCode := req.FormValue("code")
-	if userCode != "" {
-		w.Write([]byte("Success!"))
-	} else {
-		w.Write([]byte("User Authorization failed"))
+func New(cfg *ConfigT, opts Options) *Spotify {
+	spotify := &Spotify{Config: cfg, opts: opts.withDefaults(), tokens: new(tokensT)}
+	spotify.store = spotify.opts.TokenStore
+	if spotify.store == nil {
+		tokenFile := spotify.opts.TokenFile
+		if tokenFile == "" {
+			tokenFile = filepath.Join(utils.GetStateDir(), ".tokens")
+		}
+		spotify.store = NewFileTokenStore(tokenFile)
 	}
-	spotify.auth.codeChan <- userCode
+	return spotify
 }
 
-// Authorize performs the required client and user authorization steps for
-// the app to work properly.
-//
-// NOTE: If the tokens are properly saved, they will cache authorization credentials
-// to make this process more seamless.
-func (spotify *Spotify) Authorize() {
-	spotify.loadSavedTokens()
-	defer spotify.saveTokens()
-
-	tokens := spotify.tokens
-	appClient, access, refresh := tokens.AppAccessToken, tokens.UserAccessToken, tokens.UserRefreshToken
-	unixTimeNow := time.Now().Unix()
-	appTokExpired, uTokExpired := unixTimeNow > tokens.AppTokenExpiration, unixTimeNow > tokens.UserTokenExpiration
-
-	// Always want to make sure our App Client is authorized
-	if appTokExpired || appClient == "" {
-		spotify.acquireTokens("", "client")
-	}
-
-	// Case: user has existing tokens
-	if !uTokExpired && access != "" {
-		return
-	}
+// SpotifyURI defines a reference to a playable Spotify resource
+type SpotifyURI string
 
-	// Case: Existing user but tokens expired, refresh
-	if uTokExpired && refresh != "" {
-		spotify.acquireTokens(refresh, "refresh")
-		return
+// Type returns the type of resource uri references, i.e. "track" or "album".
+func (uri SpotifyURI) Type() string {
+	parts := strings.Split(string(uri), ":")
+	if len(parts) < 3 || parts[0] != "spotify" {
+		return ""
 	}
-
-	// Case: New user - getting new auth and refresh tokens
-	authCode := spotify.authorizeUser()
-	spotify.acquireTokens(authCode, "auth")
+	return parts[len(parts)-2]
 }
 
-// loadSavedTokens loads the cached tokens file (if it exists) into memory
-func (spotify *Spotify) loadSavedTokens() {
-	utils.LoadJSON(spotify.tokenFile, spotify.tokens)
-}
-
-// saveTokens saves the current tokens to a cached tokens file
-func (spotify *Spotify) saveTokens() {
-	err := utils.SaveJSON(spotify.tokenFile, spotify.tokens)
-	utils.Check(err)
-}
-
-// acquireTokens exchanges AppClient or User AuthCode/Refresh tokens for
-// access tokens that can be used to make Spotify API calls.
-func (spotify *Spotify) acquireTokens(code string, tokenType string) {
-	URL := "https://accounts.spotify.com/api/token"
-	appIdentity := []byte(spotify.Config.AppClientID + ":" + spotify.Config.AppClientSecret)
-	headers := map[string]string{
-		"Authorization": "Basic " + base64.StdEncoding.EncodeToString(appIdentity),
-		"Content-Type":  "application/x-www-form-urlencoded",
-	}
-	form := url.Values{}
-
-	switch tokenType {
-	case "client":
-		form.Set("grant_type", "client_credentials")
-
-	case "auth":
-		form.Set("grant_type", "authorization_code")
-		form.Set("code", code)
-		form.Set("redirect_uri", "http://localhost:"+spotify.Config.RedirectPort)
-
-	case "refresh":
-		form.Set("grant_type", "refresh_token")
-		form.Set("refresh_token", code)
-		form.Set("redirect_uri", "http://localhost:"+spotify.Config.RedirectPort)
-
-	default:
-		log.Fatalf("Bad value provided for tokenType arg to acquireTokens")
-	}
-
-	resp, _ := utils.MakeHTTPRequest("POST", URL, headers, form.Encode())
-	if resp.StatusCode != 200 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Fatalf("Error encountered during Authorization. INFO: %s", body)
-	}
-	var payload map[string]string
-	json.NewDecoder(resp.Body).Decode(&payload)
-
-	expiration := time.Now().Unix() + int64(3600)
-	switch tokenType {
-	case "client":
-		if clientTok, ok := payload["access_token"]; ok {
-			spotify.tokens.AppAccessToken = clientTok
-			spotify.tokens.AppTokenExpiration = expiration
-		}
-	// Same logic otherwise
-	default:
-		if userTok, ok := payload["access_token"]; ok {
-			spotify.tokens.UserAccessToken = userTok
-			spotify.tokens.UserTokenExpiration = expiration
-		}
-		if refreshTok, ok := payload["refresh_token"]; ok {
-			spotify.tokens.UserRefreshToken = refreshTok
-		}
+// ID returns the Spotify ID of the resource uri references.
+func (uri SpotifyURI) ID() string {
+	parts := strings.Split(string(uri), ":")
+	if len(parts) < 3 || parts[0] != "spotify" {
+		return ""
 	}
+	return parts[len(parts)-1]
 }
 
-// authorizeUser prompts the user to authorize his or her account
-// and waits until the authServer has received and extracted the authCode.
-func (spotify *Spotify) authorizeUser() string {
-	authURL := utils.FormatString(
-		"https://accounts.spotify.com/authorize?client_id=%s&"+
-			"response_type=code&redirect_uri=%s&"+
-			"scope=user-read-playback-state,user-modify-playback-state,user-read-currently-playing,user-library-modify",
-		spotify.Config.AppClientID,
-		"http://localhost:"+spotify.Config.RedirectPort,
-	)
-	fmt.Printf("\nPlease navigate to this URL to Authorize Spotify:\n\n%s\n", authURL)
-	_ = utils.OpenInBrowser(authURL)
-	// Block while waiting for authorization code to be received
-	// by redirect handler
-	userAuthCode := <-spotify.auth.codeChan
-	return userAuthCode
-}
-
-// SpotifyURI defines a reference to a playable Spotify resource
-type SpotifyURI string
-
 // Play starts/resumes playing music on the active device, if one exists. If not it
 // tries to play on the first device that it comes across from the Devices API.
 // NOTE: Play will return a 403 Forbbiden if Spotify already playing.
-func (spotify *Spotify) Play() {
-	device := spotify.activeOrFirstDevice()
+func (spotify *Spotify) Play() error {
+	device, err := spotify.activeOrFirstDevice()
+	if err != nil {
+		return err
+	}
 	URL := utils.FormatString(
-		"https://api.spotify.com/v1/me/player/play?device_id=%s",
+		"%s/me/player/play?device_id=%s",
+		spotify.opts.APIURL,
 		device.ID,
 	)
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("Play", resp)
+	return spotify.send("Play", "PUT", URL, nil, "")
 }
 
 // PlayOnDevice starts/resumes playing music on the target device provided.
-func (spotify *Spotify) PlayOnDevice(device Device) {
-	URL := "https://api.spotify.com/v1/me/player/"
+func (spotify *Spotify) PlayOnDevice(device Device) error {
+	URL := spotify.opts.APIURL + "/me/player/"
 	body := utils.FormatString(
 		`{"device_ids":["%s"], "play":true}`,
 		device.ID,
 	)
 
 	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-		"Content-Type":  "application/json",
+		"Content-Type": "application/json",
 	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, body)
-	handlePlaybackAPIErrorScenarios("PlayOnDevice", resp)
+	return spotify.send("PlayOnDevice", "PUT", URL, headers, body)
 }
 
 // PlayURI starts playing the specified URI on the active device, if one exists. If not it
 // tries to play on the first device that it comes across from the Devices API.
-func (spotify *Spotify) PlayURI(uri SpotifyURI) {
-	device := spotify.activeOrFirstDevice()
+func (spotify *Spotify) PlayURI(uri SpotifyURI) error {
+	return spotify.PlayURIAt(uri, PlayOptions{})
+}
+
+// PlayOptions say where in the played URI playback starts, see PlayURIAt.
+type PlayOptions struct {
+	Offset    int           // Number of tracks of the album or playlist to skip
+	OffsetURI SpotifyURI    // Track of the album or playlist to start at, instead of skipping tracks
+	Position  time.Duration // Position in the first track played
+	Device    Device        // Device to play on, instead of the active or first one
+}
+
+// playOffset is the track to start at in a play request.
+type playOffset struct {
+	Position int        `json:"position,omitempty"`
+	URI      SpotifyURI `json:"uri,omitempty"`
+}
+
+// PlayURIAt is PlayURI, starting at the track and position given by opts.
+// Offsets only apply to albums and playlists.
+func (spotify *Spotify) PlayURIAt(uri SpotifyURI, opts PlayOptions) error {
+	offset := opts.Offset != 0 || opts.OffsetURI != ""
+	switch {
+	case opts.Offset < 0 || opts.Position < 0:
+		return fmt.Errorf("offset and position must not be negative, not %d and %v", opts.Offset, opts.Position)
+	case offset && uri.Type() != "album" && uri.Type() != "playlist":
+		return fmt.Errorf("offsets only apply to albums and playlists, not %q", uri)
+	}
+	device := opts.Device
+	if device.ID == "" {
+		var err error
+		if device, err = spotify.activeOrFirstDevice(); err != nil {
+			return err
+		}
+	}
+	var body struct {
+		ContextURI SpotifyURI   `json:"context_uri,omitempty"`
+		URIs       []SpotifyURI `json:"uris,omitempty"`
+		Offset     *playOffset  `json:"offset,omitempty"`
+		PositionMS int64        `json:"position_ms,omitempty"`
+	}
 	// By default use the URI as a context_uri.
-	body := utils.FormatString(`{"context_uri":"%s"}`, string(uri))
+	body.ContextURI = uri
 	// If URI is a track, different kind of body
 	if strings.HasPrefix(string(uri), "spotify:track") {
-		body = utils.FormatString(`{"uris":["%s"]}`, string(uri))
+		body.ContextURI = ""
+		body.URIs = []SpotifyURI{uri}
+	}
+	if offset {
+		body.Offset = &playOffset{Position: opts.Offset, URI: opts.OffsetURI}
+	}
+	body.PositionMS = opts.Position.Milliseconds()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
 	}
 	URL := utils.FormatString(
-		"https://api.spotify.com/v1/me/player/play?device_id=%s",
+		"%s/me/player/play?device_id=%s",
+		spotify.opts.APIURL,
 		device.ID,
 	)
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, body)
-	handlePlaybackAPIErrorScenarios("PlayURI", resp)
+	return spotify.send("PlayURI", "PUT", URL, nil, string(data))
 }
 
 // Pause pauses playing music on any device.
 // NOTE: Pause will return a 403 Forbbiden if Spotify not already playing.
-func (spotify *Spotify) Pause() {
-	URL := "https://api.spotify.com/v1/me/player/pause"
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("Pause", resp)
+func (spotify *Spotify) Pause() error {
+	URL := spotify.opts.APIURL + "/me/player/pause"
+	return spotify.send("Pause", "PUT", URL, nil, "")
 }
 
 // NextTrack skips to the next track.
-func (spotify *Spotify) NextTrack() {
-	URL := "https://api.spotify.com/v1/me/player/next"
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("POST", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("NextTrack", resp)
+func (spotify *Spotify) NextTrack() error {
+	URL := spotify.opts.APIURL + "/me/player/next"
+	return spotify.send("NextTrack", "POST", URL, nil, "")
 }
 
 // PreviousTrack skips to the last track.
-func (spotify *Spotify) PreviousTrack() {
-	URL := "https://api.spotify.com/v1/me/player/previous"
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("POST", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("PreviousTrack", resp)
+func (spotify *Spotify) PreviousTrack() error {
+	URL := spotify.opts.APIURL + "/me/player/previous"
+	return spotify.send("PreviousTrack", "POST", URL, nil, "")
 }
 
 // Volume adjusts the playback volume to the desired percentage [0..100].
-func (spotify *Spotify) Volume(percent int) {
-	URL := fmt.Sprintf("https://api.spotify.com/v1/me/player/volume?volume_percent=%d", percent)
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
+func (spotify *Spotify) Volume(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("volume must be between 0 and 100 percent, not %d", percent)
 	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("Volume", resp)
+	URL := fmt.Sprintf("%s/me/player/volume?volume_percent=%d", spotify.opts.APIURL, percent)
+	return spotify.send("Volume", "PUT", URL, nil, "")
+}
+
+// VolumeOnDevice adjusts the volume of device to the desired percentage
+// [0..100], even if playback is not on it yet.
+func (spotify *Spotify) VolumeOnDevice(device Device, percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("volume must be between 0 and 100 percent, not %d", percent)
+	}
+	URL := fmt.Sprintf("%s/me/player/volume?volume_percent=%d&device_id=%s", spotify.opts.APIURL, percent, url.QueryEscape(device.ID))
+	return spotify.send("VolumeOnDevice", "PUT", URL, nil, "")
+}
+
+// Seek jumps to position in the playing track. Positions past the end of
+// the track skip to the next one.
+func (spotify *Spotify) Seek(position time.Duration) error {
+	if position < 0 {
+		position = 0
+	}
+	URL := fmt.Sprintf("%s/me/player/seek?position_ms=%d", spotify.opts.APIURL, position.Milliseconds())
+	return spotify.send("Seek", "PUT", URL, nil, "")
 }
 
 // Device describes a device
 type Device struct {
-	ID           string `json:"id"`
-	IsActive     bool   `json:"is_active"`
-	Name         string `json:"name"`
-	Type         string `json:"type"`
-	IsRestricted bool   `json:"is_restricted"`
+	ID            string `json:"id"`
+	IsActive      bool   `json:"is_active"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	IsRestricted  bool   `json:"is_restricted"`
+	VolumePercent int    `json:"volume_percent"`
 }
 
 // GetDevices returns all devices players
-func (spotify *Spotify) GetDevices() []Device {
-	URL := "https://api.spotify.com/v1/me/player/devices"
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
+func (spotify *Spotify) GetDevices() ([]Device, error) {
+	URL := spotify.opts.APIURL + "/me/player/devices"
+	resp, err := spotify.api("GetDevices", "GET", URL, nil, "")
+	if err != nil {
+		return nil, err
 	}
-	resp, _ := utils.MakeHTTPRequest("GET", URL, headers, "")
 	var payload struct {
 		Devices []Device `json:"devices"`
 	}
-	json.NewDecoder(resp.Body).Decode(&payload)
-	return payload.Devices
+	err = decode("GetDevices", resp, &payload)
+	return payload.Devices, err
+}
+
+// ActiveDevice returns the device playback is on, or ErrNoActiveDevice.
+func (spotify *Spotify) ActiveDevice() (Device, error) {
+	devices, err := spotify.GetDevices()
+	if err != nil {
+		return Device{}, err
+	}
+	for _, d := range devices {
+		if d.IsActive {
+			return d, nil
+		}
+	}
+	return Device{}, ErrNoActiveDevice
+}
+
+// FindDevice returns the first device whose ID, name or type contains search,
+// ignoring case. Any partial identifier works i.e. 'mbp', '064a', 'smartphone'.
+func (spotify *Spotify) FindDevice(search string) (Device, error) {
+	devices, err := spotify.GetDevices()
+	if err != nil {
+		return Device{}, err
+	}
+	if d, ok := matchDevice(devices, search); ok {
+		return d, nil
+	}
+	return Device{}, fmt.Errorf("could not find any devices '%s': %w", search, ErrDeviceNotFound)
+}
+
+// matchDevice returns the first of devices whose ID, name or type contains
+// search, ignoring case.
+func matchDevice(devices []Device, search string) (Device, bool) {
+	needle := strings.ToLower(search)
+	for _, d := range devices {
+		id, name, t := strings.ToLower(d.ID), strings.ToLower(d.Name), strings.ToLower(d.Type)
+		if strings.Contains(id, needle) ||
+			strings.Contains(name, needle) ||
+			strings.Contains(t, needle) {
+			return d, true
+		}
+	}
+	return Device{}, false
 }
 
 // SimpleSearch returns the first URI that matches the query string for the given
 // resource type.
 // NOTE `Type` must be one of { 'track', 'album', 'artist', 'playlist', 'show', 'episode' }
-func (spotify *Spotify) SimpleSearch(q string, Type string) SpotifyURI {
-	base, query := "https://api.spotify.com/v1/search", url.Values{}
+func (spotify *Spotify) SimpleSearch(q string, Type string) (SpotifyURI, error) {
+	limit := spotify.Config.SearchLimit
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	base, query := spotify.opts.APIURL+"/search", url.Values{}
 	query.Set("q", q)
 	query.Set("type", Type)
-	query.Set("limit", "1")
-	URL := utils.FormatString("%s?%s", base, query.Encode())
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
+	query.Set("limit", strconv.Itoa(limit))
+	if spotify.Config.Market != "" {
+		query.Set("market", spotify.Config.Market)
 	}
+	URL := utils.FormatString("%s?%s", base, query.Encode())
 	var payload map[string]struct {
 		Items []struct {
-			Uri SpotifyURI `json:"uri"`
+			Name string     `json:"name"`
+			Uri  SpotifyURI `json:"uri"`
 		} `json:"items"`
 	}
-	resp, _ := utils.MakeHTTPRequest("GET", URL, headers, "")
-	json.NewDecoder(resp.Body).Decode(&payload)
+	resp, err := spotify.api("SimpleSearch", "GET", URL, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err := decode("SimpleSearch", resp, &payload); err != nil {
+		return "", err
+	}
 
 	if data, ok := payload[Type+"s"]; ok && len(data.Items) > 0 {
-		return data.Items[0].Uri
+		// Prefer an exact match over a more popular result that merely contains q.
+		for _, item := range data.Items {
+			if strings.EqualFold(strings.TrimSpace(item.Name), strings.TrimSpace(q)) {
+				return item.Uri, nil
+			}
+		}
+		return data.Items[0].Uri, nil
 	}
 
-	log.Fatalf("SimpleSearch failed to find any '%s' matching search string '%s.'", Type, q)
-	return ""
+	return "", fmt.Errorf("SimpleSearch failed to find any '%s' matching search string '%s': %w", Type, q, ErrNoResults)
 }
 
 // Track describes a track
@@ -344,81 +317,217 @@ type Track struct {
 	Album struct {
 		Name string `json:"name"`
 	} `json:"album"`
-	Name    string     `json:"name"`
-	URI     SpotifyURI `json:"uri"`
-	Artists []struct {
+	Name       string     `json:"name"`
+	URI        SpotifyURI `json:"uri"`
+	DurationMS int        `json:"duration_ms"`
+	Artists    []struct {
 		Name string `json:"name"`
 	} `json:"artists"`
 }
 
+// Duration returns the length of the track.
+func (t Track) Duration() time.Duration {
+	return time.Duration(t.DurationMS) * time.Millisecond
+}
+
+// Repeat modes, see Spotify.SetRepeat
+const (
+	RepeatOff     = "off"     // Stop at the end of the context
+	RepeatContext = "context" // Repeat the album, playlist or artist
+	RepeatTrack   = "track"   // Repeat the current track
+)
+
 // StateInfo describes the current state of the Spotify playback
 type StateInfo struct {
-	IsPlaying bool  `json:"is_playing"`
-	Track     Track `json:"item"`
+	IsPlaying    bool   `json:"is_playing"`
+	ProgressMS   int    `json:"progress_ms"`
+	Track        Track  `json:"item"`
+	RepeatState  string `json:"repeat_state"`
+	ShuffleState bool   `json:"shuffle_state"`
+	Device       Device `json:"device"`
+}
+
+// Progress returns how far into the track playback is.
+func (s StateInfo) Progress() time.Duration {
+	return time.Duration(s.ProgressMS) * time.Millisecond
 }
 
 // CurrentState fetches the current state of the Spotify playback
-func (spotify *Spotify) CurrentState() StateInfo {
-	URL := "https://api.spotify.com/v1/me/player/currently-playing"
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
-	}
-	resp, _ := utils.MakeHTTPRequest("GET", URL, headers, "")
+func (spotify *Spotify) CurrentState() (StateInfo, error) {
+	URL := spotify.opts.APIURL + "/me/player"
 	var payload StateInfo
-	json.NewDecoder(resp.Body).Decode(&payload)
-	return payload
-
+	resp, err := spotify.api("CurrentState", "GET", URL, nil, "")
+	if err != nil {
+		return payload, err
+	}
+	err = decode("CurrentState", resp, &payload)
+	return payload, err
 }
 
 // ToggleShuffle toggles playback shuffle state.
-func (spotify *Spotify) ToggleShuffle(active bool) {
+func (spotify *Spotify) ToggleShuffle(active bool) error {
 	toggleState := "false"
 	if active {
 		toggleState = "true"
 	}
 
-	URL := fmt.Sprintf("https://api.spotify.com/v1/me/player/shuffle?state=%s", toggleState)
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
+	URL := fmt.Sprintf("%s/me/player/shuffle?state=%s", spotify.opts.APIURL, toggleState)
+	return spotify.send("Shuffle", "PUT", URL, nil, "")
+}
+
+// AddToQueue adds the track or episode uri to the end of the queue of the
+// active device. Albums are queued with AlbumTracks, one track at a time.
+func (spotify *Spotify) AddToQueue(uri SpotifyURI) error {
+	URL := fmt.Sprintf("%s/me/player/queue?uri=%s", spotify.opts.APIURL, url.QueryEscape(string(uri)))
+	return spotify.send("AddToQueue", "POST", URL, nil, "")
+}
+
+// Queue describes the playing item and the items queued after it
+type Queue struct {
+	CurrentlyPlaying Track   `json:"currently_playing"`
+	Queue            []Track `json:"queue"`
+}
+
+// Queue fetches the playing item and the upcoming ones, both those queued
+// with AddToQueue and the rest of the playing context.
+func (spotify *Spotify) Queue() (Queue, error) {
+	URL := spotify.opts.APIURL + "/me/player/queue"
+	var payload Queue
+	resp, err := spotify.api("Queue", "GET", URL, nil, "")
+	if err != nil {
+		return payload, err
+	}
+	err = decode("Queue", resp, &payload)
+	return payload, err
+}
+
+// AlbumTracks fetches all tracks of the album uri, in album order.
+func (spotify *Spotify) AlbumTracks(uri SpotifyURI) ([]Track, error) {
+	if uri.Type() != "album" {
+		return nil, fmt.Errorf("AlbumTracks needs an album URI, not %q", uri)
+	}
+	tracks := []Track{}
+	// Albums are fetched one page at a time, following the next page link.
+	URL := fmt.Sprintf("%s/albums/%s/tracks?limit=50", spotify.opts.APIURL, uri.ID())
+	if spotify.Config.Market != "" {
+		URL += "&market=" + url.QueryEscape(spotify.Config.Market)
+	}
+	for URL != "" {
+		var page struct {
+			Items []Track `json:"items"`
+			Next  string  `json:"next"`
+		}
+		resp, err := spotify.api("AlbumTracks", "GET", URL, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := decode("AlbumTracks", resp, &page); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, page.Items...)
+		URL = page.Next
+	}
+	return tracks, nil
+}
+
+// PlaylistTracks fetches all tracks of the playlist uri, in playlist order.
+// Episodes and tracks no longer available are left out.
+func (spotify *Spotify) PlaylistTracks(uri SpotifyURI) ([]Track, error) {
+	if uri.Type() != "playlist" {
+		return nil, fmt.Errorf("PlaylistTracks needs a playlist URI, not %q", uri)
+	}
+	tracks := []Track{}
+	URL := fmt.Sprintf("%s/playlists/%s/tracks?limit=50", spotify.opts.APIURL, uri.ID())
+	if spotify.Config.Market != "" {
+		URL += "&market=" + url.QueryEscape(spotify.Config.Market)
+	}
+	for URL != "" {
+		var page struct {
+			Items []struct {
+				Track *Track `json:"track"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		resp, err := spotify.api("PlaylistTracks", "GET", URL, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := decode("PlaylistTracks", resp, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if item.Track != nil && item.Track.URI.Type() == "track" {
+				tracks = append(tracks, *item.Track)
+			}
+		}
+		URL = page.Next
 	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("Shuffle", resp)
+	return tracks, nil
+}
+
+// SetRepeat sets the repeat mode to one of RepeatOff, RepeatContext or
+// RepeatTrack.
+func (spotify *Spotify) SetRepeat(mode string) error {
+	switch mode {
+	case RepeatOff, RepeatContext, RepeatTrack:
+	default:
+		return fmt.Errorf("bad repeat mode %q, must be one of %q, %q or %q", mode, RepeatOff, RepeatContext, RepeatTrack)
+	}
+	URL := fmt.Sprintf("%s/me/player/repeat?state=%s", spotify.opts.APIURL, mode)
+	return spotify.send("Repeat", "PUT", URL, nil, "")
 }
 
 // SaveTrack saves the current track to the user's library.
-func (spotify *Spotify) SaveTrack(trackID string) {
-	URL := fmt.Sprintf("https://api.spotify.com/v1/me/tracks?ids=%s", trackID)
-	headers := map[string]string{
-		"Authorization": "Bearer " + spotify.tokens.UserAccessToken,
+func (spotify *Spotify) SaveTrack(trackID string) error {
+	URL := fmt.Sprintf("%s/me/tracks?ids=%s", spotify.opts.APIURL, trackID)
+	return spotify.send("SaveTrack", "PUT", URL, nil, "")
+}
+
+// User describes a Spotify user
+type User struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Product     string `json:"product"` // Only set with the user-read-private scope
+	Country     string `json:"country"` // Only set with the user-read-private scope
+}
+
+// CurrentUser fetches the profile of the logged in user.
+func (spotify *Spotify) CurrentUser() (User, error) {
+	URL := spotify.opts.APIURL + "/me"
+	var payload User
+	resp, err := spotify.api("CurrentUser", "GET", URL, nil, "")
+	if err != nil {
+		return payload, err
 	}
-	resp, _ := utils.MakeHTTPRequest("PUT", URL, headers, "")
-	handlePlaybackAPIErrorScenarios("SaveTrack", resp)
+	err = decode("CurrentUser", resp, &payload)
+	return payload, err
 }
 
-// activeOrFirstDevice returns the active device. If no active, return the first.
-func (spotify *Spotify) activeOrFirstDevice() Device {
-	devices := spotify.GetDevices()
-	chosen := devices[0]
+// PlaybackDevice returns the device Play and PlayURI start playback on: the
+// active device, or else the configured DefaultDevice or the first.
+func (spotify *Spotify) PlaybackDevice() (Device, error) {
+	return spotify.activeOrFirstDevice()
+}
+
+// activeOrFirstDevice returns the active device. If no active, return the
+// configured DefaultDevice or else the first.
+func (spotify *Spotify) activeOrFirstDevice() (Device, error) {
+	devices, err := spotify.GetDevices()
+	if err != nil {
+		return Device{}, err
+	}
+	if len(devices) == 0 {
+		return Device{}, ErrNoDevices
+	}
 	for i := range devices {
 		if devices[i].IsActive {
-			chosen = devices[i]
+			return devices[i], nil
 		}
 	}
-	return chosen
-}
-
-// handlePlaybackAPIErrorScenarios handles HTTP response code error scenarios for
-// the Spotify Player API methods.
-func handlePlaybackAPIErrorScenarios(operation string, r *http.Response) {
-	switch r.StatusCode {
-	case 204:
-		// ok
-	case 400:
-		body, _ := ioutil.ReadAll(r.Body)
-		log.Fatalf("%s operation encountered unexpected client error. INFO: %s", operation, body)
-	case 403:
-		log.Fatalf("%s operation encountered 403 Forbidden. Is this operation allowed right now?", operation)
-	case 404:
-		log.Fatalf("%s operation encountered 404 Not Found. Are there active devices?", operation)
+	if spotify.Config.DefaultDevice != "" {
+		if d, ok := matchDevice(devices, spotify.Config.DefaultDevice); ok {
+			return d, nil
+		}
 	}
+	return devices[0], nil
 }
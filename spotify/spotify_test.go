@@ -0,0 +1,986 @@
+package spotify_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/charlesyu108/spotify-cli/spotify/spotifytest"
+)
+
+var (
+	laptop  = spotify.Device{ID: "064a9a0b", Name: "Charles's MBP", Type: "Computer"}
+	speaker = spotify.Device{ID: "7bc21f5e", Name: "Kitchen", Type: "Speaker", IsActive: true}
+	track   = spotifytest.Item{Type: "track", Name: "The Less I Know The Better", URI: "spotify:track:6K4t31amVTZDgR3sKmwUJJ", Artists: []string{"Tame Impala"}, Album: "Currents", Duration: 216 * time.Second}
+	album   = spotifytest.Item{Type: "album", Name: "Currents", URI: "spotify:album:79dL7FLiJFOO0EoehUHQBv", Tracks: []spotifytest.Item{
+		{Type: "track", Name: "Let It Happen", URI: "spotify:track:2X485T9Z5Ly0xyaghN73ed", Artists: []string{"Tame Impala"}, Album: "Currents", Duration: 467 * time.Second},
+		track,
+	}}
+)
+
+func newFakeServer(t *testing.T) *spotifytest.Server {
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddDevice(laptop)
+	srv.AddDevice(speaker)
+	srv.AddItem(album)
+	return srv
+}
+
+func TestAuthorize(t *testing.T) {
+	srv := newFakeServer(t)
+	spotifytest.NewTestSpotify(t, srv)
+
+	// One app token plus one refreshed user token
+	if n := srv.TokensIssued(); n != 2 {
+		t.Errorf("Expected 2 tokens to be issued but got %d", n)
+	}
+
+	t.Run("Rejects bad credentials", func(t *testing.T) {
+		srv.SetCredentials(spotifytest.ClientID, "another-secret")
+		defer srv.SetCredentials(spotifytest.ClientID, spotifytest.ClientSecret)
+
+		s := spotify.New(&spotify.ConfigT{AppClientID: spotifytest.ClientID, AppClientSecret: spotifytest.ClientSecret}, spotifytest.TestOptions(t, srv))
+		var apiErr *spotify.APIError
+		if err := s.Authorize(); !errors.As(err, &apiErr) || apiErr.Reason != "invalid_client" {
+			t.Errorf("Expected invalid_client *APIError but got %v", err)
+		}
+	})
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotify.New(srv.Config(), spotifytest.TestOptions(t, srv))
+	if err := s.VerifyCredentials(); err != nil {
+		t.Errorf("VerifyCredentials returned %v", err)
+	}
+	if n := srv.TokensIssued(); n != 1 {
+		t.Errorf("Expected 1 app token to be issued but got %d", n)
+	}
+
+	cfg := srv.Config()
+	cfg.AppClientSecret = "another-secret"
+	s = spotify.New(cfg, spotifytest.TestOptions(t, srv))
+	var apiErr *spotify.APIError
+	if err := s.VerifyCredentials(); !errors.As(err, &apiErr) || apiErr.Reason != "invalid_client" {
+		t.Errorf("Expected invalid_client *APIError but got %v", err)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	if err := s.PlayURI(album.URI); err != nil {
+		t.Fatalf("PlayURI returned %v", err)
+	}
+	p := srv.Player()
+	if !p.IsPlaying || p.DeviceID != speaker.ID || p.Context != album.URI {
+		t.Errorf("Expected album playing on active device but got %+v", p)
+	}
+
+	if err := s.Pause(); err != nil {
+		t.Fatalf("Pause returned %v", err)
+	}
+	if err := s.Play(); err != nil {
+		t.Fatalf("Play returned %v", err)
+	}
+	if !srv.Player().IsPlaying {
+		t.Errorf("Expected playback to resume")
+	}
+
+	t.Run("Pausing twice is forbidden", func(t *testing.T) {
+		s.Pause()
+		var apiErr *spotify.APIError
+		if err := s.Pause(); !errors.As(err, &apiErr) || apiErr.StatusCode != 403 {
+			t.Errorf("Expected 403 *APIError but got %v", err)
+		}
+	})
+}
+
+func TestPlayWithoutDevices(t *testing.T) {
+	srv := spotifytest.NewServer()
+	t.Cleanup(srv.Close)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	if err := s.Play(); !errors.Is(err, spotify.ErrNoDevices) {
+		t.Errorf("Expected ErrNoDevices but got %v", err)
+	}
+}
+
+func TestSimpleSearch(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	uri, err := s.SimpleSearch("currents", "album")
+	if err != nil || uri != album.URI {
+		t.Errorf("Expected %s but got %s, %v", album.URI, uri, err)
+	}
+
+	if _, err := s.SimpleSearch("does not exist", "album"); !errors.Is(err, spotify.ErrNoResults) {
+		t.Errorf("Expected ErrNoResults but got %v", err)
+	}
+}
+
+var findDeviceTest = []struct {
+	search   string
+	expected spotify.Device
+	found    bool
+}{
+	{"mbp", laptop, true},
+	{"064A", laptop, true},
+	{"speaker", speaker, true},
+	{"kitchen", speaker, true},
+	{"smartphone", spotify.Device{}, false},
+}
+
+func TestFindDevice(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	for _, tt := range findDeviceTest {
+		t.Run(tt.search, func(t *testing.T) {
+			d, err := s.FindDevice(tt.search)
+			if !tt.found {
+				if !errors.Is(err, spotify.ErrDeviceNotFound) {
+					t.Errorf("Expected ErrDeviceNotFound but got %v", err)
+				}
+				return
+			}
+			if err != nil || d.ID != tt.expected.ID {
+				t.Errorf("Expected %s but got %s, %v", tt.expected.ID, d.ID, err)
+			}
+		})
+	}
+
+	if err := s.PlayOnDevice(laptop); err != nil {
+		t.Fatalf("PlayOnDevice returned %v", err)
+	}
+	if p := srv.Player(); p.DeviceID != laptop.ID || !p.IsPlaying {
+		t.Errorf("Expected playback on %s but got %+v", laptop.ID, p)
+	}
+}
+
+func TestTrackNavigationAndSave(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	if err := s.PlayURI(album.URI); err != nil {
+		t.Fatalf("PlayURI returned %v", err)
+	}
+	if err := s.NextTrack(); err != nil {
+		t.Fatalf("NextTrack returned %v", err)
+	}
+
+	state, err := s.CurrentState()
+	if err != nil {
+		t.Fatalf("CurrentState returned %v", err)
+	}
+	if !state.IsPlaying || state.Track.URI != track.URI || state.Track.Artists[0].Name != "Tame Impala" {
+		t.Errorf("Expected %s to be playing but got %+v", track.URI, state)
+	}
+
+	if err := s.SaveTrack("6K4t31amVTZDgR3sKmwUJJ"); err != nil {
+		t.Fatalf("SaveTrack returned %v", err)
+	}
+	if saved := srv.SavedTracks(); !reflect.DeepEqual(saved, []string{"6K4t31amVTZDgR3sKmwUJJ"}) {
+		t.Errorf("Expected track to be saved but got %v", saved)
+	}
+
+	if err := s.PreviousTrack(); err != nil {
+		t.Fatalf("PreviousTrack returned %v", err)
+	}
+	if current, _ := srv.Player().Current(); current.URI != album.Tracks[0].URI {
+		t.Errorf("Expected first track but got %s", current.URI)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+	if err := s.PlayURI(album.URI); err != nil {
+		t.Fatalf("PlayURI returned %v", err)
+	}
+
+	if err := s.Seek(90 * time.Second); err != nil {
+		t.Fatalf("Seek returned %v", err)
+	}
+	state, err := s.CurrentState()
+	if err != nil {
+		t.Fatalf("CurrentState returned %v", err)
+	}
+	if state.Progress() != 90*time.Second || state.Track.Duration() != 467*time.Second {
+		t.Errorf("Expected to be 1m30s into a 7m47s track but got %v of %v", state.Progress(), state.Track.Duration())
+	}
+
+	if err := s.Seek(-time.Second); err != nil {
+		t.Fatalf("Seek returned %v", err)
+	}
+	if p := srv.Player().Progress; p != 0 {
+		t.Errorf("Expected negative positions to seek to the start but got %v", p)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+	if err := s.PlayURI(album.URI); err != nil {
+		t.Fatalf("PlayURI returned %v", err)
+	}
+
+	for _, mode := range []string{spotify.RepeatTrack, spotify.RepeatContext, spotify.RepeatOff} {
+		if err := s.SetRepeat(mode); err != nil {
+			t.Fatalf("SetRepeat returned %v", err)
+		}
+		state, err := s.CurrentState()
+		if err != nil {
+			t.Fatalf("CurrentState returned %v", err)
+		}
+		if state.RepeatState != mode || state.Device.ID != speaker.ID {
+			t.Errorf("Expected repeat %s on %s but got %+v", mode, speaker.ID, state)
+		}
+	}
+
+	n := len(srv.Requests())
+	if err := s.SetRepeat("album"); err == nil {
+		t.Errorf("Expected an unknown mode to be an error")
+	}
+	if len(srv.Requests()) != n {
+		t.Errorf("Expected no request for an unknown mode")
+	}
+}
+
+func TestVolume(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	if err := s.Volume(35); err != nil {
+		t.Fatalf("Volume returned %v", err)
+	}
+	d, err := s.ActiveDevice()
+	if err != nil || d.ID != speaker.ID || d.VolumePercent != 35 {
+		t.Errorf("Expected %s at 35%% but got %+v, %v", speaker.ID, d, err)
+	}
+
+	n := len(srv.Requests())
+	for _, percent := range []int{-1, 101} {
+		if err := s.Volume(percent); err == nil {
+			t.Errorf("Expected volume %d to be an error", percent)
+		}
+	}
+	if len(srv.Requests()) != n {
+		t.Errorf("Expected no request for volumes out of range")
+	}
+
+	t.Run("Without an active device", func(t *testing.T) {
+		srv := spotifytest.NewServer()
+		t.Cleanup(srv.Close)
+		srv.AddDevice(laptop)
+		s := spotifytest.NewTestSpotify(t, srv)
+		if _, err := s.ActiveDevice(); !errors.Is(err, spotify.ErrNoActiveDevice) {
+			t.Errorf("Expected ErrNoActiveDevice but got %v", err)
+		}
+	})
+
+	t.Run("On another device", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := spotifytest.NewTestSpotify(t, srv)
+		before := srv.Player().VolumePercent
+		if err := s.VolumeOnDevice(laptop, 20); err != nil {
+			t.Fatalf("VolumeOnDevice returned %v", err)
+		}
+		if d, err := s.FindDevice(laptop.ID); err != nil || d.VolumePercent != 20 {
+			t.Errorf("Expected %s at 20%% but got %+v, %v", laptop.ID, d, err)
+		}
+		if d, err := s.PlaybackDevice(); err != nil || d.ID != speaker.ID || d.VolumePercent != before {
+			t.Errorf("Expected playback to stay on %s at %d%% but got %+v, %v", speaker.ID, before, d, err)
+		}
+		if err := s.VolumeOnDevice(spotify.Device{ID: "unknown"}, 20); err == nil {
+			t.Errorf("Expected an unknown device to be an error")
+		}
+	})
+}
+
+func TestSpotifyURI(t *testing.T) {
+	tests := []struct {
+		uri  spotify.SpotifyURI
+		Type string
+		ID   string
+	}{
+		{"spotify:track:6K4t31amVTZDgR3sKmwUJJ", "track", "6K4t31amVTZDgR3sKmwUJJ"},
+		{"spotify:album:79dL7FLiJFOO0EoehUHQBv", "album", "79dL7FLiJFOO0EoehUHQBv"},
+		{"spotify:user:someone:playlist:37i9dQZF1DXcBWIGoYBM5M", "playlist", "37i9dQZF1DXcBWIGoYBM5M"},
+		{"https://open.spotify.com/track/6K4t31amVTZDgR3sKmwUJJ", "", ""},
+		{"spotify:track", "", ""},
+	}
+	for _, tt := range tests {
+		if tt.uri.Type() != tt.Type || tt.uri.ID() != tt.ID {
+			t.Errorf("Expected %s to be %q with ID %q but got %q and %q", tt.uri, tt.Type, tt.ID, tt.uri.Type(), tt.uri.ID())
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	srv := newFakeServer(t)
+	single := spotifytest.Item{Type: "track", Name: "Borderline", URI: "spotify:track:5hM5arv9KDbCHS0k9uqwjr", Artists: []string{"Tame Impala"}}
+	srv.AddItem(single)
+	s := spotifytest.NewTestSpotify(t, srv)
+	if err := s.PlayURI(album.URI); err != nil {
+		t.Fatalf("PlayURI returned %v", err)
+	}
+
+	if err := s.AddToQueue(single.URI); err != nil {
+		t.Fatalf("AddToQueue returned %v", err)
+	}
+	queue, err := s.Queue()
+	if err != nil {
+		t.Fatalf("Queue returned %v", err)
+	}
+	var upcoming []spotify.SpotifyURI
+	for _, t := range queue.Queue {
+		upcoming = append(upcoming, t.URI)
+	}
+	if queue.CurrentlyPlaying.URI != album.Tracks[0].URI || !reflect.DeepEqual(upcoming, []spotify.SpotifyURI{single.URI, track.URI}) {
+		t.Errorf("Expected %s playing with %s and %s upcoming but got %+v", album.Tracks[0].URI, single.URI, track.URI, queue)
+	}
+
+	if err := s.NextTrack(); err != nil {
+		t.Fatalf("NextTrack returned %v", err)
+	}
+	if current, _ := srv.Player().Current(); current.URI != single.URI {
+		t.Errorf("Expected the queued track to play next but got %s", current.URI)
+	}
+}
+
+func TestAlbumTracks(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.SetPageSize(1)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	tracks, err := s.AlbumTracks(album.URI)
+	if err != nil {
+		t.Fatalf("AlbumTracks returned %v", err)
+	}
+	if len(tracks) != 2 || tracks[0].URI != album.Tracks[0].URI || tracks[1].URI != track.URI {
+		t.Errorf("Expected both tracks of the album in order but got %+v", tracks)
+	}
+
+	if _, err := s.AlbumTracks(track.URI); err == nil {
+		t.Errorf("Expected a track URI to be an error")
+	}
+}
+
+func TestPlayURIAt(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	if err := s.PlayURIAt(album.URI, spotify.PlayOptions{Offset: 1, Position: 80 * time.Second}); err != nil {
+		t.Fatalf("PlayURIAt returned %v", err)
+	}
+	if p := srv.Player(); p.Index != 1 || p.Progress != 80*time.Second || !p.IsPlaying {
+		t.Errorf("Expected to play the second track from 1:20 but got %+v", p)
+	}
+
+	if err := s.PlayURIAt(album.URI, spotify.PlayOptions{OffsetURI: album.Tracks[0].URI}); err != nil {
+		t.Fatalf("PlayURIAt returned %v", err)
+	}
+	if p := srv.Player(); p.Index != 0 || p.Progress != 0 {
+		t.Errorf("Expected to play the first track from the start but got %+v", p)
+	}
+
+	if err := s.PlayURIAt(track.URI, spotify.PlayOptions{Position: time.Minute}); err != nil {
+		t.Fatalf("PlayURIAt returned %v", err)
+	}
+	if p := srv.Player(); p.Progress != time.Minute {
+		t.Errorf("Expected to play the track from 1:00 but got %+v", p)
+	}
+
+	if err := s.PlayURIAt(album.URI, spotify.PlayOptions{Device: laptop}); err != nil {
+		t.Fatalf("PlayURIAt returned %v", err)
+	}
+	if p := srv.Player(); p.DeviceID != laptop.ID || p.Context != album.URI || !p.IsPlaying {
+		t.Errorf("Expected the album to play on %s but got %+v", laptop.ID, p)
+	}
+
+	var apiErr *spotify.APIError
+	if err := s.PlayURIAt(album.URI, spotify.PlayOptions{Offset: 2}); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("Expected an offset past the album to be a 404 *APIError but got %v", err)
+	}
+	for _, opts := range []spotify.PlayOptions{{Offset: -1}, {Position: -time.Second}} {
+		if err := s.PlayURIAt(album.URI, opts); err == nil {
+			t.Errorf("Expected %+v to be an error", opts)
+		}
+	}
+	if err := s.PlayURIAt(track.URI, spotify.PlayOptions{Offset: 1}); err == nil {
+		t.Errorf("Expected an offset into a track to be an error")
+	}
+}
+
+func TestPlaylistTracks(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.SetPageSize(1)
+	playlist := spotifytest.Item{Type: "playlist", Name: "Morning", URI: "spotify:playlist:37i9dQZF1DX0XUsuxWHRQd", Tracks: []spotifytest.Item{track, album.Tracks[0]}}
+	srv.AddItem(playlist)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	tracks, err := s.PlaylistTracks(playlist.URI)
+	if err != nil {
+		t.Fatalf("PlaylistTracks returned %v", err)
+	}
+	if len(tracks) != 2 || tracks[0].URI != track.URI || tracks[1].URI != album.Tracks[0].URI {
+		t.Errorf("Expected both tracks of the playlist in order but got %+v", tracks)
+	}
+
+	if _, err := s.PlaylistTracks(album.URI); err == nil {
+		t.Errorf("Expected an album URI to be an error")
+	}
+}
+
+func TestScriptedFailure(t *testing.T) {
+	srv := newFakeServer(t)
+	s := spotifytest.NewTestSpotify(t, srv)
+
+	srv.Fail("PUT", "/v1/me/player/volume", spotifytest.Failure{Status: 400, Body: "Bad Request"})
+	var apiErr *spotify.APIError
+	if err := s.Volume(30); !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Errorf("Expected 400 *APIError but got %v", err)
+	}
+	if err := s.Volume(30); err != nil {
+		t.Errorf("Expected failure to be served once but got %v", err)
+	}
+	if v := srv.Player().VolumePercent; v != 30 {
+		t.Errorf("Expected volume 30 but got %d", v)
+	}
+}
+
+// cachedTokens reads the tokens file created by testOptions.
+func cachedTokens(t *testing.T, tokenFile string) map[string]interface{} {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens map[string]interface{}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestTokenRefresh(t *testing.T) {
+	t.Run("Retries once after 401", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := spotifytest.NewTestSpotify(t, srv)
+		issued := srv.TokensIssued()
+
+		srv.ExpireTokens()
+		if err := s.Volume(20); err != nil {
+			t.Fatalf("Expected Volume to succeed after refresh but got %v", err)
+		}
+		if n := srv.TokensIssued(); n != issued+1 {
+			t.Errorf("Expected exactly one refresh but %d tokens were issued", n-issued)
+		}
+	})
+
+	t.Run("Gives up after second 401", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := spotifytest.NewTestSpotify(t, srv)
+
+		srv.Fail("PUT", "/v1/me/player/volume", spotifytest.Failure{Status: 401, Times: 2})
+		var apiErr *spotify.APIError
+		if err := s.Volume(20); !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+			t.Errorf("Expected 401 *APIError but got %v", err)
+		}
+	})
+
+	t.Run("Refreshes proactively before expiry", func(t *testing.T) {
+		srv := newFakeServer(t)
+		// Shorter than the expiry skew, so every token is stale on arrival.
+		srv.SetTokenLifetime(30)
+		s := spotifytest.NewTestSpotify(t, srv)
+		issued := srv.TokensIssued()
+
+		if _, err := s.GetDevices(); err != nil {
+			t.Fatalf("GetDevices returned %v", err)
+		}
+		if n := srv.TokensIssued(); n != issued+1 {
+			t.Errorf("Expected a proactive refresh but %d tokens were issued", n-issued)
+		}
+	})
+
+	t.Run("Honors expires_in", func(t *testing.T) {
+		srv := newFakeServer(t)
+		srv.SetTokenLifetime(7200)
+		opts := spotifytest.TestOptions(t, srv)
+
+		before := time.Now().Unix()
+		if err := spotify.New(srv.Config(), opts).Authorize(); err != nil {
+			t.Fatalf("Authorize returned %v", err)
+		}
+		expiration := int64(cachedTokens(t, opts.TokenFile)["UserTokenExpiration"].(float64))
+		if expiration < before+7200 || expiration > time.Now().Unix()+7200 {
+			t.Errorf("Expected expiration two hours from now but got %d", expiration-before)
+		}
+	})
+}
+
+// newRetryingSpotify returns a Spotify authorized against srv that retries
+// with the given policy.
+func newRetryingSpotify(t *testing.T, srv *spotifytest.Server, policy spotify.RetryPolicy) *spotify.Spotify {
+	opts := spotifytest.TestOptions(t, srv)
+	opts.Retry = &policy
+	s := spotify.New(srv.Config(), opts)
+	if err := s.Authorize(); err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+	return s
+}
+
+// countRequests counts the requests srv received for "METHOD /path".
+func countRequests(srv *spotifytest.Server, route string) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if r == route || strings.HasPrefix(r, route+"?") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRetry(t *testing.T) {
+	fast := spotify.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxWait: time.Second}
+
+	t.Run("Retries server errors", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := newRetryingSpotify(t, srv, fast)
+
+		srv.Fail("GET", "/v1/me/player/devices", spotifytest.Failure{Status: 503, Times: 2})
+		if _, err := s.GetDevices(); err != nil {
+			t.Fatalf("Expected GetDevices to succeed but got %v", err)
+		}
+		if n := countRequests(srv, "GET /v1/me/player/devices"); n != 3 {
+			t.Errorf("Expected 3 attempts but got %d", n)
+		}
+	})
+
+	t.Run("Stops after MaxRetries", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := newRetryingSpotify(t, srv, fast)
+
+		srv.Fail("GET", "/v1/me/player/devices", spotifytest.Failure{Status: 500, Times: 10})
+		var apiErr *spotify.APIError
+		if _, err := s.GetDevices(); !errors.As(err, &apiErr) || apiErr.StatusCode != 500 {
+			t.Errorf("Expected 500 *APIError but got %v", err)
+		}
+		if n := countRequests(srv, "GET /v1/me/player/devices"); n != 4 {
+			t.Errorf("Expected 4 attempts but got %d", n)
+		}
+	})
+
+	t.Run("Does not retry POST on server errors", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := newRetryingSpotify(t, srv, fast)
+		s.PlayURI(album.URI)
+
+		srv.Fail("POST", "/v1/me/player/next", spotifytest.Failure{Status: 502})
+		if err := s.NextTrack(); err == nil {
+			t.Errorf("Expected NextTrack to fail")
+		}
+		if n := countRequests(srv, "POST /v1/me/player/next"); n != 1 {
+			t.Errorf("Expected 1 attempt but got %d", n)
+		}
+	})
+
+	t.Run("Retries token requests on server errors", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		opts.Retry = &fast
+
+		srv.Fail("POST", "/api/token", spotifytest.Failure{Status: 503, Times: 2})
+		if err := spotify.New(srv.Config(), opts).Authorize(); err != nil {
+			t.Fatalf("Expected Authorize to succeed but got %v", err)
+		}
+		if n := countRequests(srv, "POST /api/token"); n != 4 {
+			t.Errorf("Expected 2 failed and 2 successful token requests but got %d", n)
+		}
+	})
+
+	t.Run("Honors Retry-After", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := newRetryingSpotify(t, srv, fast)
+
+		srv.Fail("PUT", "/v1/me/player/volume", spotifytest.Failure{Status: 429, Header: http.Header{"Retry-After": {"1"}}})
+		start := time.Now()
+		if err := s.Volume(40); err != nil {
+			t.Fatalf("Expected Volume to succeed but got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("Expected to wait for Retry-After but only waited %v", elapsed)
+		}
+	})
+
+	t.Run("Gives up when Retry-After exceeds MaxWait", func(t *testing.T) {
+		srv := newFakeServer(t)
+		s := newRetryingSpotify(t, srv, fast)
+
+		srv.Fail("PUT", "/v1/me/player/volume", spotifytest.Failure{Status: 429, Header: http.Header{"Retry-After": {"120"}}})
+		var apiErr *spotify.APIError
+		if err := s.Volume(40); !errors.As(err, &apiErr) || apiErr.RetryAfter != 120*time.Second {
+			t.Errorf("Expected 429 *APIError with RetryAfter but got %v", err)
+		}
+	})
+}
+
+func TestPKCE(t *testing.T) {
+	srv := newFakeServer(t)
+	cfg := srv.Config()
+	cfg.AppClientSecret, cfg.AuthFlow = "", spotify.AuthFlowPKCE
+
+	s := spotify.New(cfg, spotifytest.TestOptions(t, srv))
+	if err := s.Authorize(); err != nil {
+		t.Fatalf("Expected refresh without client secret to succeed but got %v", err)
+	}
+	// Only the user token is refreshed, there is no app token without a secret.
+	if n := srv.TokensIssued(); n != 1 {
+		t.Errorf("Expected 1 token to be issued but got %d", n)
+	}
+	if _, err := s.GetDevices(); err != nil {
+		t.Errorf("GetDevices returned %v", err)
+	}
+}
+
+// approveInBrowser plays the user of a headless login: it waits for the
+// authorization URL to be printed to prompts, follows it on srv and pastes
+// the resulting redirect URL into answers.
+func approveInBrowser(t *testing.T, prompts io.Reader, answers io.WriteCloser) {
+	scanner := bufio.NewScanner(prompts)
+	for scanner.Scan() {
+		authURL := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(authURL, "http") {
+			continue
+		}
+		// Keep consuming prompts, so that the login can go on to read the answer.
+		go io.Copy(ioutil.Discard, prompts)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(authURL)
+		if err != nil {
+			t.Errorf("Could not open authorization URL: %v", err)
+			break
+		}
+		resp.Body.Close()
+		io.WriteString(answers, resp.Header.Get("Location")+"\n")
+		break
+	}
+	answers.Close()
+}
+
+func TestHeadlessLogin(t *testing.T) {
+	for _, flow := range []string{spotify.AuthFlowClientSecret, spotify.AuthFlowPKCE} {
+		t.Run(flow, func(t *testing.T) {
+			srv := newFakeServer(t)
+			cfg := srv.Config()
+			cfg.AuthFlow = flow
+			if flow == spotify.AuthFlowPKCE {
+				cfg.AppClientSecret = ""
+			}
+
+			prompts, stdout := io.Pipe()
+			stdin, answers := io.Pipe()
+			opts := spotifytest.TestOptions(t, srv)
+			opts.NoBrowser, opts.Stdin, opts.Stdout = true, stdin, stdout
+			go approveInBrowser(t, prompts, answers)
+
+			err := spotify.New(cfg, opts).Login()
+			stdout.Close()
+			if err != nil {
+				t.Fatalf("Login returned %v", err)
+			}
+
+			tokens := cachedTokens(t, opts.TokenFile)
+			if tokens["UserAccessToken"] == "" || tokens["UserRefreshToken"] != srv.RefreshToken() {
+				t.Errorf("Expected fresh user tokens to be cached but got %v", tokens)
+			}
+		})
+	}
+
+	t.Run("Rejects forged state", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		opts.NoBrowser, opts.Stdout = true, ioutil.Discard
+		opts.Stdin = strings.NewReader("http://localhost:5555/?code=auth-code-1&state=forged\n")
+
+		if err := spotify.New(srv.Config(), opts).Login(); !errors.Is(err, spotify.ErrStateMismatch) {
+			t.Errorf("Expected ErrStateMismatch but got %v", err)
+		}
+	})
+}
+
+// loginWhile runs login, headless, and calls meanwhile once the user is
+// asked to log in, before they approve.
+func loginWhile(t *testing.T, opts spotify.Options, login func(opts spotify.Options) error, meanwhile func()) error {
+	prompts, stdout := io.Pipe()
+	stdin, answers := io.Pipe()
+	opts.NoBrowser, opts.Stdin, opts.Stdout = true, stdin, stdout
+
+	seen, approved := io.Pipe()
+	go approveInBrowser(t, seen, answers)
+	go func() {
+		scanner := bufio.NewScanner(prompts)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "http") {
+				meanwhile()
+			}
+			io.WriteString(approved, scanner.Text()+"\n")
+		}
+		approved.Close()
+	}()
+
+	err := login(opts)
+	stdout.Close()
+	return err
+}
+
+func TestLoginLocking(t *testing.T) {
+	fresh := map[string]interface{}{"UserAccessToken": "saved-meanwhile", "UserRefreshToken": "refresh-meanwhile", "UserTokenExpiration": time.Now().Add(time.Hour).Unix()}
+	saveFresh := func(t *testing.T, tokenFile string) {
+		data, _ := json.Marshal(fresh)
+		if err := ioutil.WriteFile(tokenFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Leaves the tokens unlocked while waiting", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		if err := ioutil.WriteFile(opts.TokenFile, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		authorize := func(opts spotify.Options) error { return spotify.New(srv.Config(), opts).Authorize() }
+		err := loginWhile(t, opts, authorize, func() {
+			locked := make(chan struct{})
+			go func() {
+				if unlock, err := spotify.NewFileTokenStore(opts.TokenFile).Lock(); err == nil {
+					unlock()
+				}
+				close(locked)
+			}()
+			select {
+			case <-locked:
+			case <-time.After(5 * time.Second):
+				t.Errorf("Expected the tokens to be unlocked while the user logs in")
+			}
+		})
+		if err != nil {
+			t.Fatalf("Authorize returned %v", err)
+		}
+	})
+
+	t.Run("Authorize keeps tokens saved meanwhile", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		if err := ioutil.WriteFile(opts.TokenFile, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		authorize := func(opts spotify.Options) error { return spotify.New(srv.Config(), opts).Authorize() }
+		if err := loginWhile(t, opts, authorize, func() { saveFresh(t, opts.TokenFile) }); err != nil {
+			t.Fatalf("Authorize returned %v", err)
+		}
+		if tokens := cachedTokens(t, opts.TokenFile); tokens["UserAccessToken"] != fresh["UserAccessToken"] {
+			t.Errorf("Expected the tokens saved meanwhile to be kept but got %v", tokens)
+		}
+	})
+
+	t.Run("Login replaces tokens saved meanwhile", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		login := func(opts spotify.Options) error { return spotify.New(srv.Config(), opts).Login() }
+		if err := loginWhile(t, opts, login, func() { saveFresh(t, opts.TokenFile) }); err != nil {
+			t.Fatalf("Login returned %v", err)
+		}
+		tokens := cachedTokens(t, opts.TokenFile)
+		if tokens["UserAccessToken"] == fresh["UserAccessToken"] || tokens["UserRefreshToken"] != srv.RefreshToken() {
+			t.Errorf("Expected the new login to replace the tokens saved meanwhile but got %v", tokens)
+		}
+	})
+
+	t.Run("Logout waits for a refresh", func(t *testing.T) {
+		srv := newFakeServer(t)
+		opts := spotifytest.TestOptions(t, srv)
+		unlock, err := spotify.NewFileTokenStore(opts.TokenFile).Lock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() { done <- spotify.New(srv.Config(), opts).Logout(false) }()
+		select {
+		case err := <-done:
+			t.Fatalf("Expected Logout to wait for the tokens to be unlocked but it returned %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		saveFresh(t, opts.TokenFile)
+		unlock()
+		if err := <-done; err != nil {
+			t.Fatalf("Logout returned %v", err)
+		}
+		if tokens := cachedTokens(t, opts.TokenFile); tokens["UserRefreshToken"] != fresh["UserRefreshToken"] || tokens["UserAccessToken"] != "" {
+			t.Errorf("Expected only the refresh token saved meanwhile to be kept but got %v", tokens)
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	srv := newFakeServer(t)
+	opts := spotifytest.TestOptions(t, srv)
+	s := spotify.New(srv.Config(), opts)
+	if info, _ := s.TokenInfo(); !info.LoggedIn || info.UserTokenValid {
+		t.Errorf("Expected only a refresh token to be cached but got %+v", info)
+	}
+	if err := s.Authorize(); err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+
+	info, err := s.TokenInfo()
+	if err != nil {
+		t.Fatalf("TokenInfo returned %v", err)
+	}
+	if !info.UserTokenValid || !info.AppTokenValid {
+		t.Errorf("Expected valid tokens after authorizing but got %+v", info)
+	}
+	if scopes := strings.Join(info.Scopes, " "); scopes != srv.Scopes() {
+		t.Errorf("Expected scopes %q but got %q", srv.Scopes(), scopes)
+	}
+	user, err := s.CurrentUser()
+	if err != nil || user.ID != "testuser" {
+		t.Errorf("CurrentUser returned %+v, %v", user, err)
+	}
+
+	if err := s.Logout(false); err != nil {
+		t.Fatalf("Logout returned %v", err)
+	}
+	tokens := cachedTokens(t, opts.TokenFile)
+	if tokens["UserAccessToken"] != "" || tokens["AppAccessToken"] != "" || tokens["UserRefreshToken"] != srv.RefreshToken() {
+		t.Errorf("Expected only the refresh token to be kept but got %v", tokens)
+	}
+	if info, _ := s.TokenInfo(); !info.LoggedIn || info.UserTokenValid || info.AppTokenValid {
+		t.Errorf("Expected expired tokens after logging out but got %+v", info)
+	}
+
+	if err := s.Logout(true); err != nil {
+		t.Fatalf("Logout returned %v", err)
+	}
+	if _, err := os.Stat(opts.TokenFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the tokens file to be deleted but got %v", err)
+	}
+	if info, _ := s.TokenInfo(); info.LoggedIn {
+		t.Errorf("Expected to be logged out but got %+v", info)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.SetScopes("user-read-playback-state user-modify-playback-state")
+	opts := spotifytest.TestOptions(t, srv)
+	s := spotify.New(srv.Config(), opts)
+	if err := s.Authorize(); err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+
+	t.Run("Detects missing scopes before sending", func(t *testing.T) {
+		var scopeErr *spotify.ScopeError
+		err := s.SaveTrack("6K4t31amVTZDgR3sKmwUJJ")
+		if !errors.As(err, &scopeErr) || !reflect.DeepEqual(scopeErr.Missing, []string{spotify.ScopeUserLibraryModify}) {
+			t.Fatalf("Expected *ScopeError missing %s but got %v", spotify.ScopeUserLibraryModify, err)
+		}
+		for _, req := range srv.Requests() {
+			if strings.HasPrefix(req, "PUT /v1/me/tracks") {
+				t.Errorf("Expected no request to be sent but got %s", req)
+			}
+		}
+	})
+
+	t.Run("Checks scopes up front", func(t *testing.T) {
+		if err := s.CheckScopes("AddToQueue", "GetDevices"); err != nil {
+			t.Errorf("Expected granted scopes to pass but got %v", err)
+		}
+		var scopeErr *spotify.ScopeError
+		err := s.CheckScopes("AddToQueue", "SaveTrack")
+		if !errors.As(err, &scopeErr) || scopeErr.Operation != "SaveTrack" || scopeErr.Err != nil {
+			t.Errorf("Expected *ScopeError for SaveTrack without a request but got %v", err)
+		}
+	})
+
+	t.Run("Detects scopes rejected by the Web API", func(t *testing.T) {
+		srv.SetScopes("user-modify-playback-state")
+		defer srv.SetScopes("user-read-playback-state user-modify-playback-state")
+
+		var scopeErr *spotify.ScopeError
+		var apiErr *spotify.APIError
+		if _, err := s.GetDevices(); !errors.As(err, &scopeErr) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected *ScopeError wrapping a 403 *APIError but got %v", err)
+		}
+	})
+
+	t.Run("Asks for scopes of private playlists", func(t *testing.T) {
+		track := spotifytest.Item{Type: "track", Name: "Sunrise", URI: "spotify:track:5LvBCCsZuvRPNhnFCTSqfr", Artists: []string{"Norah Jones"}}
+		public := spotifytest.Item{Type: "playlist", Name: "Morning", URI: "spotify:playlist:37i9dQZF1DX0XUsuxWHRQd", Tracks: []spotifytest.Item{track}}
+		private := spotifytest.Item{Type: "playlist", Name: "Mine", URI: "spotify:playlist:5Rrf7mqN8uus2AaQQQNdc1", Tracks: []spotifytest.Item{track}, Private: true}
+		srv.AddItem(public)
+		srv.AddItem(private)
+
+		if _, err := s.PlaylistTracks(public.URI); err != nil {
+			t.Errorf("Expected public playlists to need no scopes but got %v", err)
+		}
+		var scopeErr *spotify.ScopeError
+		_, err := s.PlaylistTracks(private.URI)
+		if !errors.As(err, &scopeErr) || !reflect.DeepEqual(scopeErr.Missing, []string{spotify.ScopePlaylistReadPrivate}) {
+			t.Errorf("Expected *ScopeError missing %s but got %v", spotify.ScopePlaylistReadPrivate, err)
+		}
+	})
+
+	t.Run("Re-consents to the union of scopes", func(t *testing.T) {
+		prompts, stdout := io.Pipe()
+		stdin, answers := io.Pipe()
+		opts.NoBrowser, opts.Stdin, opts.Stdout = true, stdin, stdout
+		go approveInBrowser(t, prompts, answers)
+
+		err := spotify.New(srv.Config(), opts).Login(spotify.ScopeUserTopRead)
+		stdout.Close()
+		if err != nil {
+			t.Fatalf("Login returned %v", err)
+		}
+
+		granted := strings.Fields(srv.Scopes())
+		for _, scope := range append([]string{spotify.ScopeUserTopRead}, spotify.DefaultScopes...) {
+			if !contains(granted, scope) {
+				t.Errorf("Expected %s to be granted but got %v", scope, granted)
+			}
+		}
+		s := spotify.New(srv.Config(), opts)
+		if err := s.Authorize(); err != nil {
+			t.Fatalf("Authorize returned %v", err)
+		}
+		if err := s.SaveTrack("6K4t31amVTZDgR3sKmwUJJ"); err != nil {
+			t.Errorf("SaveTrack returned %v", err)
+		}
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package spotifytest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+)
+
+// TestOptions returns Options pointing at srv with a temporary tokens file
+// that only caches the refresh token of srv, so that authorizing refreshes
+// tokens. The file is removed once the test finished.
+func TestOptions(t testing.TB, srv *Server) spotify.Options {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	opts := srv.Options()
+	opts.TokenFile = filepath.Join(dir, ".tokens")
+	cached, _ := json.Marshal(map[string]string{"UserRefreshToken": srv.RefreshToken()})
+	if err := ioutil.WriteFile(opts.TokenFile, cached, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// NewTestSpotify returns a Spotify authorized against srv, see TestOptions.
+func NewTestSpotify(t testing.TB, srv *Server) *spotify.Spotify {
+	s := spotify.New(srv.Config(), TestOptions(t, srv))
+	if err := s.Authorize(); err != nil {
+		t.Fatalf("Authorize returned %v", err)
+	}
+	return s
+}
@@ -0,0 +1,932 @@
+// Package spotifytest provides an in-process stand-in for the Spotify Web API
+// and Accounts service, for writing hermetic tests against package spotify.
+//
+// The server keeps a small in-memory player that can be scripted before and
+// inspected after exercising a client:
+//
+//	srv := spotifytest.NewServer()
+//	defer srv.Close()
+//	srv.AddDevice(spotify.Device{ID: "abc", Name: "Kitchen", Type: "Speaker"})
+//	srv.AddItem(spotifytest.Item{Type: "track", Name: "Borderline", URI: "spotify:track:1"})
+//	client := spotify.New(cfg, srv.Options())
+package spotifytest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+)
+
+// DefaultScopes are the scopes granted with a refresh token unless a user
+// authorizes through the /authorize endpoint with other scopes.
+var DefaultScopes = strings.Join(spotify.DefaultScopes, " ")
+
+// Credentials the server accepts unless changed with SetCredentials.
+const (
+	ClientID     = "test-client-id"
+	ClientSecret = "test-client-secret"
+)
+
+// Item is a searchable and playable catalog entry. Albums and playlists list
+// the tracks they contain in Tracks.
+type Item struct {
+	Type     string // One of { 'track', 'album', 'artist', 'playlist' }
+	Name     string
+	URI      spotify.SpotifyURI
+	Artists  []string
+	Album    string
+	Tracks   []Item
+	Duration time.Duration // Length of a track
+	Private  bool          // Whether reading a playlist needs the playlist-read-private scope
+}
+
+// Player describes the state of the fake player.
+type Player struct {
+	IsPlaying     bool
+	DeviceID      string
+	Context       spotify.SpotifyURI // URI of the playing album/playlist/artist, if any
+	Tracks        []Item             // Tracks of the playing context
+	Index         int                // Index of the current track in Tracks
+	Progress      time.Duration      // Position in the current track
+	VolumePercent int                // Volume of the device playback is on, others keep theirs in their Device
+	Shuffle       bool
+	Repeat        string // One of { 'off', 'context', 'track' }, empty means 'off'
+	Queue         []Item // Tracks added to the queue, played before the rest of Tracks
+}
+
+// Current returns the playing track, if any.
+func (p Player) Current() (Item, bool) {
+	if p.Index < 0 || p.Index >= len(p.Tracks) {
+		return Item{}, false
+	}
+	return p.Tracks[p.Index], true
+}
+
+// Failure describes a scripted error response.
+type Failure struct {
+	Status int
+	Body   string
+	Header http.Header
+	Times  int // Number of requests to fail. Zero means one
+}
+
+// Server is a fake Spotify Web API and Accounts service.
+type Server struct {
+	URL string // Base URL of the server, i.e. http://127.0.0.1:1234
+
+	srv *httptest.Server
+
+	mu           sync.Mutex
+	clientID     string
+	clientSecret string
+	accessToken  string
+	refreshToken string
+	appToken     string
+	tokenCount   int
+	tokenTTL     int
+	devices      []spotify.Device
+	catalog      []Item
+	player       Player
+	saved        []string
+	scopes       string
+	user         spotify.User
+	codes        map[string]authRequest
+	failures     map[string][]Failure
+	requests     []string
+	pageSize     int
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		clientID:     ClientID,
+		clientSecret: ClientSecret,
+		refreshToken: "refresh-token",
+		tokenTTL:     3600,
+		scopes:       DefaultScopes,
+		user:         spotify.User{ID: "testuser", DisplayName: "Test User", Product: "premium", Country: "US"},
+		player:       Player{Index: -1, VolumePercent: 50},
+		codes:        map[string]authRequest{},
+		failures:     map[string][]Failure{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/api/token", s.handleToken)
+	mux.HandleFunc("/v1/", s.authenticated(s.handleAPI))
+	s.srv = httptest.NewServer(s.record(mux))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Options returns spotify.Options that point a client at this server.
+func (s *Server) Options() spotify.Options {
+	return spotify.Options{
+		APIURL:      s.URL + "/v1",
+		AccountsURL: s.URL,
+		HTTPClient:  s.srv.Client(),
+	}
+}
+
+// Config returns a config holding the credentials the server accepts.
+func (s *Server) Config() *spotify.ConfigT {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &spotify.ConfigT{AppClientID: s.clientID, AppClientSecret: s.clientSecret, RedirectPort: "5555"}
+}
+
+// SetCredentials changes the app credentials the Accounts service accepts.
+func (s *Server) SetCredentials(clientID, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientID, s.clientSecret = clientID, clientSecret
+}
+
+// RefreshToken returns the refresh token the server currently accepts.
+func (s *Server) RefreshToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshToken
+}
+
+// TokensIssued returns how many access tokens the Accounts service has issued.
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCount
+}
+
+// SetTokenLifetime changes the expires_in, in seconds, of tokens issued from now on.
+func (s *Server) SetTokenLifetime(seconds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = seconds
+}
+
+// ExpireTokens invalidates every access token issued so far, so that the
+// next Web API call responds with 401 Unauthorized.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken, s.appToken = "", ""
+}
+
+// Scopes returns the space separated scopes granted to the user.
+func (s *Server) Scopes() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scopes
+}
+
+// SetScopes changes the space separated scopes granted to the user, as if
+// they had authorized the app for them.
+func (s *Server) SetScopes(scopes string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scopes = scopes
+}
+
+// SetUser changes the profile returned for the logged in user.
+func (s *Server) SetUser(u spotify.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = u
+}
+
+// AddDevice makes d available for playback.
+func (s *Server) AddDevice(d spotify.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices = append(s.devices, d)
+}
+
+// Devices returns the available devices.
+func (s *Server) Devices() []spotify.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]spotify.Device(nil), s.devices...)
+}
+
+// AddItem adds it to the searchable catalog.
+func (s *Server) AddItem(it Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.catalog = append(s.catalog, it)
+}
+
+// Player returns a snapshot of the player state.
+func (s *Server) Player() Player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.player
+	p.Tracks = append([]Item(nil), p.Tracks...)
+	p.Queue = append([]Item(nil), p.Queue...)
+	return p
+}
+
+// SetPlayer replaces the player state.
+func (s *Server) SetPlayer(p Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.player = p
+}
+
+// SavedTracks returns the IDs of the tracks saved to the library.
+func (s *Server) SavedTracks() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.saved...)
+}
+
+// Fail makes the next f.Times requests for method and path respond with f.
+// path excludes the query string, i.e. "/v1/me/player/play".
+func (s *Server) Fail(method, path string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.Times == 0 {
+		f.Times = 1
+	}
+	key := method + " " + path
+	s.failures[key] = append(s.failures[key], f)
+}
+
+// SetPageSize limits pages of paged endpoints to n items, regardless of the
+// requested limit, so that clients have to follow the next links.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// Requests returns every request received so far as "METHOD /path?query".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// record logs every request and serves scripted failures before handing
+// the request over to next.
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		key := r.Method + " " + r.URL.Path
+		var failure *Failure
+		if queue := s.failures[key]; len(queue) > 0 {
+			f := queue[0]
+			failure = &f
+			if queue[0].Times--; queue[0].Times == 0 {
+				s.failures[key] = queue[1:]
+			}
+		}
+		s.mu.Unlock()
+
+		if failure == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for k, v := range failure.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(failure.Status)
+		fmt.Fprint(w, failure.Body)
+	})
+}
+
+// authenticated rejects Web API requests without a valid bearer token.
+// Endpoints under /me only accept user tokens, like the real Web API.
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		userOnly := strings.HasPrefix(r.URL.Path, "/v1/me")
+		s.mu.Lock()
+		valid := token != "" && (token == s.accessToken || (!userOnly && token == s.appToken))
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "The access token expired", "")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authRequest is an authorization code handed out by handleAuthorize.
+type authRequest struct {
+	challenge   string
+	redirectURI string
+	scopes      string
+}
+
+// handleAuthorize emulates a user approving the app: it immediately redirects
+// back to redirect_uri with a new authorization code and the given state.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if query.Get("client_id") != s.clientID || query.Get("response_type") != "code" || err != nil || redirectURI.Host == "" {
+		http.Error(w, "INVALID_CLIENT: Invalid client or redirect URI", http.StatusBadRequest)
+		return
+	}
+	challenge := query.Get("code_challenge")
+	if challenge != "" && query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "Invalid code_challenge_method", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("auth-code-%d", len(s.codes)+1)
+	scopes := strings.Join(strings.FieldsFunc(query.Get("scope"), func(r rune) bool { return r == ',' || r == ' ' }), " ")
+	s.codes[code] = authRequest{challenge: challenge, redirectURI: redirectURI.String(), scopes: scopes}
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	if state := query.Get("state"); state != "" {
+		callback.Set("state", state)
+	}
+	redirectURI.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// handleToken emulates the Accounts service token endpoint. Confidential
+// clients authenticate with their secret, PKCE clients with their client_id.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, secret, confidential := r.BasicAuth()
+	if confidential && (id != s.clientID || secret != s.clientSecret) ||
+		!confidential && r.FormValue("client_id") != s.clientID {
+		writeAuthError(w, "invalid_client", "Invalid client")
+		return
+	}
+
+	s.tokenCount++
+	token := fmt.Sprintf("access-token-%d", s.tokenCount)
+	payload := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   s.tokenTTL,
+	}
+
+	switch r.FormValue("grant_type") {
+	case "client_credentials":
+		if !confidential {
+			writeAuthError(w, "invalid_client", "Invalid client secret")
+			return
+		}
+		s.appToken = token
+	case "authorization_code":
+		code := r.FormValue("code")
+		req, ok := s.codes[code]
+		if !ok || req.redirectURI != r.FormValue("redirect_uri") {
+			writeAuthError(w, "invalid_grant", "Invalid authorization code")
+			return
+		}
+		if !confidential {
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if req.challenge == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+				writeAuthError(w, "invalid_grant", "code_verifier was incorrect")
+				return
+			}
+		}
+		delete(s.codes, code)
+		s.accessToken = token
+		s.scopes = req.scopes
+		payload["refresh_token"] = s.refreshToken
+		payload["scope"] = s.scopes
+	case "refresh_token":
+		if r.FormValue("refresh_token") != s.refreshToken {
+			writeAuthError(w, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		s.accessToken = token
+		payload["scope"] = s.scopes
+	default:
+		writeAuthError(w, "unsupported_grant_type", "grant_type must be client_credentials, authorization_code or refresh_token")
+		return
+	}
+	writeJSON(w, payload)
+}
+
+// routeScopes are the scopes the user must have granted to use a route.
+var routeScopes = map[string]string{
+	"GET /me/player/devices":           spotify.ScopeUserReadPlaybackState,
+	"GET /me/player":                   spotify.ScopeUserReadPlaybackState,
+	"GET /me/player/currently-playing": spotify.ScopeUserReadCurrentlyPlaying,
+	"PUT /me/player":                   spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/play":              spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/pause":             spotify.ScopeUserModifyPlaybackState,
+	"POST /me/player/next":             spotify.ScopeUserModifyPlaybackState,
+	"POST /me/player/previous":         spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/volume":            spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/seek":              spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/shuffle":           spotify.ScopeUserModifyPlaybackState,
+	"PUT /me/player/repeat":            spotify.ScopeUserModifyPlaybackState,
+	"POST /me/player/queue":            spotify.ScopeUserModifyPlaybackState,
+	"GET /me/player/queue":             spotify.ScopeUserReadPlaybackState,
+	"PUT /me/tracks":                   spotify.ScopeUserLibraryModify,
+}
+
+// handleAPI emulates the Web API endpoints used by package spotify.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	route := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/v1")
+	if scope, ok := routeScopes[route]; ok && !strings.Contains(" "+s.scopes+" ", " "+scope+" ") {
+		writeError(w, http.StatusForbidden, "Insufficient client scope", "")
+		return
+	}
+
+	if strings.HasPrefix(route, "GET /albums/") && strings.HasSuffix(route, "/tracks") {
+		id := strings.TrimSuffix(strings.TrimPrefix(route, "GET /albums/"), "/tracks")
+		s.albumTracks(w, r, spotify.SpotifyURI("spotify:album:"+id))
+		return
+	}
+
+	if strings.HasPrefix(route, "GET /playlists/") && strings.HasSuffix(route, "/tracks") {
+		id := strings.TrimSuffix(strings.TrimPrefix(route, "GET /playlists/"), "/tracks")
+		s.playlistTracks(w, r, spotify.SpotifyURI("spotify:playlist:"+id))
+		return
+	}
+
+	switch route {
+	case "GET /me":
+		writeJSON(w, s.user)
+
+	case "GET /me/player/devices":
+		devices := append([]spotify.Device{}, s.devices...)
+		for i := range devices {
+			if devices[i].IsActive {
+				devices[i].VolumePercent = s.player.VolumePercent
+			}
+		}
+		writeJSON(w, map[string]interface{}{"devices": devices})
+
+	case "PUT /me/player", "PUT /me/player/":
+		var body struct {
+			DeviceIDs []string `json:"device_ids"`
+			Play      bool     `json:"play"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.DeviceIDs) != 1 {
+			writeError(w, http.StatusBadRequest, "Malformed json", "")
+			return
+		}
+		if !s.setActiveDevice(body.DeviceIDs[0]) {
+			writeError(w, http.StatusNotFound, "Device not found", "")
+			return
+		}
+		if body.Play {
+			s.player.IsPlaying = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PUT /me/player/play":
+		if id := query.Get("device_id"); id != "" && !s.setActiveDevice(id) {
+			writeError(w, http.StatusNotFound, "Device not found", "")
+			return
+		}
+		if s.player.DeviceID == "" {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+			return
+		}
+		var body struct {
+			ContextURI spotify.SpotifyURI   `json:"context_uri"`
+			URIs       []spotify.SpotifyURI `json:"uris"`
+			Offset     *struct {
+				Position int                `json:"position"`
+				URI      spotify.SpotifyURI `json:"uri"`
+			} `json:"offset"`
+			PositionMS int64 `json:"position_ms"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, "Malformed json", "")
+				return
+			}
+		}
+		if body.ContextURI != "" || len(body.URIs) > 0 {
+			if !s.load(body.ContextURI, body.URIs) {
+				writeError(w, http.StatusBadRequest, "Invalid context uri", "")
+				return
+			}
+		}
+		if body.Offset != nil {
+			index := body.Offset.Position
+			for i, it := range s.player.Tracks {
+				if body.Offset.URI != "" && it.URI == body.Offset.URI {
+					index = i
+				}
+			}
+			if index < 0 || index >= len(s.player.Tracks) || (body.Offset.URI != "" && s.player.Tracks[index].URI != body.Offset.URI) {
+				writeError(w, http.StatusNotFound, "Offset not found", "")
+				return
+			}
+			s.player.Index = index
+		}
+		if body.PositionMS > 0 {
+			s.player.Progress = time.Duration(body.PositionMS) * time.Millisecond
+		}
+		s.player.IsPlaying = true
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PUT /me/player/pause":
+		if s.player.DeviceID == "" {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+			return
+		}
+		if !s.player.IsPlaying {
+			writeError(w, http.StatusForbidden, "Player command failed: Restriction violated", "UNKNOWN")
+			return
+		}
+		s.player.IsPlaying = false
+		w.WriteHeader(http.StatusNoContent)
+
+	case "POST /me/player/next", "POST /me/player/previous":
+		if s.player.DeviceID == "" {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+			return
+		}
+		if strings.HasSuffix(route, "next") && len(s.player.Queue) > 0 {
+			// Queued tracks play next, then the context carries on.
+			next := append([]Item{s.player.Queue[0]}, s.player.Tracks[s.player.Index+1:]...)
+			s.player.Tracks = append(s.player.Tracks[:s.player.Index+1:s.player.Index+1], next...)
+			s.player.Queue = s.player.Queue[1:]
+			s.player.Index++
+		} else if strings.HasSuffix(route, "next") && s.player.Index < len(s.player.Tracks)-1 {
+			s.player.Index++
+		} else if strings.HasSuffix(route, "previous") && s.player.Index > 0 {
+			s.player.Index--
+		}
+		s.player.Progress = 0
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PUT /me/player/volume":
+		percent, err := strconv.Atoi(query.Get("volume_percent"))
+		if err != nil || percent < 0 || percent > 100 {
+			writeError(w, http.StatusBadRequest, "Invalid volume_percent", "")
+			return
+		}
+		if id := query.Get("device_id"); id != "" && id != s.player.DeviceID {
+			// Devices keep their own volume until playback moves to them.
+			found := false
+			for i := range s.devices {
+				if s.devices[i].ID == id {
+					s.devices[i].VolumePercent, found = percent, true
+				}
+			}
+			if !found {
+				writeError(w, http.StatusNotFound, "Device not found", "")
+				return
+			}
+		} else {
+			s.player.VolumePercent = percent
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PUT /me/player/seek":
+		position, err := strconv.Atoi(query.Get("position_ms"))
+		if err != nil || position < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid position_ms", "")
+			return
+		}
+		track, ok := s.player.Current()
+		if !ok {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+			return
+		}
+		s.player.Progress = time.Duration(position) * time.Millisecond
+		// Like Spotify, seeking past the end skips to the next track.
+		if s.player.Progress >= track.Duration && s.player.Index < len(s.player.Tracks)-1 {
+			s.player.Index++
+			s.player.Progress = 0
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PUT /me/player/repeat":
+		switch state := query.Get("state"); state {
+		case spotify.RepeatOff, spotify.RepeatContext, spotify.RepeatTrack:
+			s.player.Repeat = state
+		default:
+			writeError(w, http.StatusBadRequest, "Invalid state", "")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "POST /me/player/queue":
+		if s.player.DeviceID == "" {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+			return
+		}
+		it, ok := s.lookup(spotify.SpotifyURI(query.Get("uri")))
+		if !ok || it.Type != "track" {
+			writeError(w, http.StatusBadRequest, "Invalid uri", "")
+			return
+		}
+		s.player.Queue = append(s.player.Queue, it)
+		w.WriteHeader(http.StatusNoContent)
+
+	case "GET /me/player/queue":
+		queue := []map[string]interface{}{}
+		for _, t := range s.player.Queue {
+			queue = append(queue, trackJSON(t))
+		}
+		var current interface{}
+		if track, ok := s.player.Current(); ok {
+			current = trackJSON(track)
+			for _, t := range s.player.Tracks[s.player.Index+1:] {
+				queue = append(queue, trackJSON(t))
+			}
+		}
+		writeJSON(w, map[string]interface{}{"currently_playing": current, "queue": queue})
+
+	case "PUT /me/player/shuffle":
+		state, err := strconv.ParseBool(query.Get("state"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid state", "")
+			return
+		}
+		s.player.Shuffle = state
+		w.WriteHeader(http.StatusNoContent)
+
+	case "GET /me/player":
+		track, ok := s.player.Current()
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		repeat := s.player.Repeat
+		if repeat == "" {
+			repeat = spotify.RepeatOff
+		}
+		writeJSON(w, map[string]interface{}{
+			"is_playing":    s.player.IsPlaying,
+			"progress_ms":   s.player.Progress.Milliseconds(),
+			"item":          trackJSON(track),
+			"repeat_state":  repeat,
+			"shuffle_state": s.player.Shuffle,
+			"device":        s.activeDevice(),
+		})
+
+	case "GET /me/player/currently-playing":
+		track, ok := s.player.Current()
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"is_playing":  s.player.IsPlaying,
+			"progress_ms": s.player.Progress.Milliseconds(),
+			"item":        trackJSON(track),
+		})
+
+	case "GET /search":
+		s.search(w, query.Get("q"), query.Get("type"), query.Get("limit"))
+
+	case "PUT /me/tracks":
+		ids := strings.Split(query.Get("ids"), ",")
+		s.saved = append(s.saved, ids...)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		writeError(w, http.StatusNotFound, "Service not found", "")
+	}
+}
+
+// activeDevice returns the device playback is on.
+func (s *Server) activeDevice() spotify.Device {
+	for _, d := range s.devices {
+		if d.ID == s.player.DeviceID {
+			d.VolumePercent = s.player.VolumePercent
+			return d
+		}
+	}
+	return spotify.Device{}
+}
+
+// setActiveDevice makes the device with the given ID the active one.
+func (s *Server) setActiveDevice(id string) bool {
+	found := -1
+	for i := range s.devices {
+		if s.devices[i].ID == id {
+			found = i
+		}
+	}
+	if found < 0 {
+		return false
+	}
+	for i := range s.devices {
+		s.devices[i].IsActive = i == found
+	}
+	if id != s.player.DeviceID {
+		// The volume of the player is that of the device it plays on.
+		for i := range s.devices {
+			if s.devices[i].ID == s.player.DeviceID {
+				s.devices[i].VolumePercent = s.player.VolumePercent
+			}
+		}
+		s.player.DeviceID = id
+		s.player.VolumePercent = s.devices[found].VolumePercent
+	}
+	return true
+}
+
+// load replaces the playing context with contextURI or the tracks in uris.
+func (s *Server) load(contextURI spotify.SpotifyURI, uris []spotify.SpotifyURI) bool {
+	var tracks []Item
+	if contextURI != "" {
+		it, ok := s.lookup(contextURI)
+		if !ok {
+			return false
+		}
+		tracks = it.Tracks
+		if it.Type == "artist" {
+			tracks = s.tracksBy(it.Name)
+		}
+	}
+	for _, uri := range uris {
+		it, ok := s.lookup(uri)
+		if !ok || it.Type != "track" {
+			return false
+		}
+		tracks = append(tracks, it)
+	}
+	s.player.Context = contextURI
+	s.player.Tracks = tracks
+	s.player.Index = 0
+	s.player.Progress = 0
+	return true
+}
+
+// albumTracks serves the tracks of the album uri one page at a time.
+func (s *Server) albumTracks(w http.ResponseWriter, r *http.Request, uri spotify.SpotifyURI) {
+	album, ok := s.lookup(uri)
+	if !ok || album.Type != "album" {
+		writeError(w, http.StatusNotFound, "Non existing id", "")
+		return
+	}
+	s.trackPage(w, r, album.Tracks, trackJSON)
+}
+
+// playlistTracks serves the tracks of the playlist uri one page at a time,
+// each wrapped in a playlist item.
+func (s *Server) playlistTracks(w http.ResponseWriter, r *http.Request, uri spotify.SpotifyURI) {
+	playlist, ok := s.lookup(uri)
+	if !ok || playlist.Type != "playlist" {
+		writeError(w, http.StatusNotFound, "Not found.", "")
+		return
+	}
+	if playlist.Private && !strings.Contains(" "+s.scopes+" ", " "+spotify.ScopePlaylistReadPrivate+" ") {
+		writeError(w, http.StatusForbidden, "Insufficient client scope", "")
+		return
+	}
+	s.trackPage(w, r, playlist.Tracks, func(it Item) map[string]interface{} {
+		return map[string]interface{}{"track": trackJSON(it)}
+	})
+}
+
+// trackPage serves the page of tracks asked for by the limit and offset
+// query parameters, with a link to the next page.
+func (s *Server) trackPage(w http.ResponseWriter, r *http.Request, tracks []Item, itemJSON func(Item) map[string]interface{}) {
+	query := r.URL.Query()
+	limit, offset := 20, 0
+	if arg := query.Get("limit"); arg != "" {
+		limit, _ = strconv.Atoi(arg)
+	}
+	if arg := query.Get("offset"); arg != "" {
+		offset, _ = strconv.Atoi(arg)
+	}
+	if s.pageSize > 0 && limit > s.pageSize {
+		limit = s.pageSize
+	}
+	if limit < 1 || limit > 50 || offset < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid limit or offset", "")
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for i := offset; i < len(tracks) && i < offset+limit; i++ {
+		items = append(items, itemJSON(tracks[i]))
+	}
+	var next interface{}
+	if offset+limit < len(tracks) {
+		query.Set("offset", strconv.Itoa(offset+limit))
+		query.Set("limit", strconv.Itoa(limit))
+		next = s.URL + r.URL.Path + "?" + query.Encode()
+	}
+	writeJSON(w, map[string]interface{}{"items": items, "next": next, "total": len(tracks)})
+}
+
+// lookup finds the catalog item for uri, including tracks of albums and playlists.
+func (s *Server) lookup(uri spotify.SpotifyURI) (Item, bool) {
+	for _, it := range s.catalog {
+		if it.URI == uri {
+			return it, true
+		}
+		for _, t := range it.Tracks {
+			if t.URI == uri {
+				return t, true
+			}
+		}
+	}
+	return Item{}, false
+}
+
+// tracksBy returns all catalog tracks by the named artist.
+func (s *Server) tracksBy(artist string) []Item {
+	var tracks []Item
+	for _, it := range s.catalog {
+		candidates := append([]Item{it}, it.Tracks...)
+		for _, t := range candidates {
+			if t.Type != "track" {
+				continue
+			}
+			for _, a := range t.Artists {
+				if a == artist {
+					tracks = append(tracks, t)
+					break
+				}
+			}
+		}
+	}
+	return tracks
+}
+
+// search emulates the search endpoint using case-insensitive substring matches on names.
+func (s *Server) search(w http.ResponseWriter, q, Type, limitArg string) {
+	limit, err := strconv.Atoi(limitArg)
+	if limitArg == "" {
+		limit, err = 20, nil
+	}
+	if q == "" || Type == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid search query", "")
+		return
+	}
+	items := []map[string]interface{}{}
+	for _, it := range s.catalog {
+		if it.Type == Type && strings.Contains(strings.ToLower(it.Name), strings.ToLower(q)) && len(items) < limit {
+			items = append(items, map[string]interface{}{"name": it.Name, "uri": it.URI})
+		}
+	}
+	writeJSON(w, map[string]interface{}{Type + "s": map[string]interface{}{"items": items}})
+}
+
+// trackJSON renders a track the way the Web API does.
+func trackJSON(t Item) map[string]interface{} {
+	artists := []map[string]string{}
+	for _, a := range t.Artists {
+		artists = append(artists, map[string]string{"name": a})
+	}
+	return map[string]interface{}{
+		"name":        t.Name,
+		"uri":         t.URI,
+		"duration_ms": t.Duration.Milliseconds(),
+		"album":       map[string]string{"name": t.Album},
+		"artists":     artists,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Web API style error object.
+func writeError(w http.ResponseWriter, status int, message string, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body := map[string]interface{}{"status": status, "message": message}
+	if reason != "" {
+		body["reason"] = reason
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// writeAuthError writes an Accounts service style error.
+func writeAuthError(w http.ResponseWriter, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
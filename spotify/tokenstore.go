@@ -0,0 +1,271 @@
+package spotify
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+)
+
+// ErrNoTokens is returned by a TokenStore that has no tokens saved.
+var ErrNoTokens = errors.New("no tokens saved")
+
+// ErrTokensUnreadable is returned when saved tokens cannot be decrypted,
+// usually because the passphrase or key changed.
+var ErrTokensUnreadable = errors.New("saved tokens could not be decrypted, was the passphrase or key changed? Log in again to replace them")
+
+// TokenStore persists the tokens of a Spotify between runs. The tokens are
+// handed over encoded, stores only need to keep the bytes safe.
+type TokenStore interface {
+	Load() ([]byte, error) // Returns ErrNoTokens if nothing was saved
+	Save(data []byte) error
+	Delete() error // Deleting an empty store is not an error
+}
+
+// TokenLocker is implemented by token stores shared between processes. While
+// a process holds the lock, no other process refreshes the saved tokens, so
+// that refresh tokens rotated by Spotify are not lost.
+type TokenLocker interface {
+	Lock() (unlock func(), err error)
+}
+
+// FileTokenStore saves tokens in plain text to a file only its owner can read.
+type FileTokenStore struct {
+	Path string
+}
+
+// NewFileTokenStore returns a FileTokenStore saving tokens to path.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load implements TokenStore.
+func (s *FileTokenStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) || err == nil && len(data) == 0 {
+		return nil, ErrNoTokens
+	}
+	return data, err
+}
+
+// Save implements TokenStore.
+func (s *FileTokenStore) Save(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.Path, data)
+}
+
+// Delete implements TokenStore.
+func (s *FileTokenStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Lock implements TokenLocker, so that processes sharing the file take
+// turns refreshing the tokens.
+func (s *FileTokenStore) Lock() (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return nil, err
+	}
+	return utils.LockFile(s.Path)
+}
+
+// Encrypted token files start with this header, followed by the salt of the
+// key derivation, the nonce and the AES-256-GCM sealed tokens.
+var encryptedHeader = []byte("spotify-cli-tokens-v1\n")
+
+const (
+	saltSize         = 16
+	keyIterations    = 100000
+	keyFileSize      = 32
+	encryptedKeySize = 32
+)
+
+// EncryptedFileTokenStore saves tokens to a file, encrypted with a key
+// derived from a secret: a passphrase or the contents of a key file.
+// Plain text tokens found in the file are read, and encrypted when saved.
+type EncryptedFileTokenStore struct {
+	file   FileTokenStore
+	secret []byte
+}
+
+// NewEncryptedFileTokenStore returns an EncryptedFileTokenStore saving tokens
+// to path, encrypted with a key derived from secret.
+func NewEncryptedFileTokenStore(path string, secret []byte) *EncryptedFileTokenStore {
+	return &EncryptedFileTokenStore{file: FileTokenStore{Path: path}, secret: secret}
+}
+
+// Load implements TokenStore.
+func (s *EncryptedFileTokenStore) Load() ([]byte, error) {
+	data, err := s.file.Load()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, encryptedHeader) {
+		return data, nil
+	}
+
+	data = data[len(encryptedHeader):]
+	if len(data) < saltSize {
+		return nil, ErrTokensUnreadable
+	}
+	aead, err := s.cipher(data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrTokensUnreadable
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], encryptedHeader)
+	if err != nil {
+		return nil, ErrTokensUnreadable
+	}
+	return plain, nil
+}
+
+// Save implements TokenStore.
+func (s *EncryptedFileTokenStore) Save(data []byte) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := s.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	sealed := append(append([]byte(nil), encryptedHeader...), salt...)
+	sealed = append(sealed, nonce...)
+	sealed = aead.Seal(sealed, nonce, data, encryptedHeader)
+	return s.file.Save(sealed)
+}
+
+// Delete implements TokenStore.
+func (s *EncryptedFileTokenStore) Delete() error {
+	return s.file.Delete()
+}
+
+// Lock implements TokenLocker.
+func (s *EncryptedFileTokenStore) Lock() (unlock func(), err error) {
+	return s.file.Lock()
+}
+
+// cipher returns the AES-256-GCM cipher keyed by the secret and salt.
+func (s *EncryptedFileTokenStore) cipher(salt []byte) (cipher.AEAD, error) {
+	if len(s.secret) == 0 {
+		return nil, errors.New("cannot encrypt tokens without a passphrase or key")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256(s.secret, salt, keyIterations, encryptedKeySize))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from secret as in RFC 8018.
+func pbkdf2SHA256(secret, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, secret)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// LoadOrCreateKeyFile returns the key saved at path for use with
+// NewEncryptedFileTokenStore. A new random key is saved first if there is none.
+func LoadOrCreateKeyFile(path string) ([]byte, error) {
+	store := &FileTokenStore{Path: path}
+	unlock, err := store.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	key, err := ioutil.ReadFile(path)
+	if err == nil && len(key) > 0 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, keyFileSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := store.Save(key); err != nil {
+		return nil, fmt.Errorf("could not save the token key: %w", err)
+	}
+	return key, nil
+}
+
+// MemoryTokenStore keeps tokens in memory only, so that nothing is written
+// to disk. Every run starts logged out.
+type MemoryTokenStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewMemoryTokenStore returns an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return new(MemoryTokenStore)
+}
+
+// Load implements TokenStore.
+func (s *MemoryTokenStore) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return nil, ErrNoTokens
+	}
+	return append([]byte(nil), s.data...), nil
+}
+
+// Save implements TokenStore.
+func (s *MemoryTokenStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+// Delete implements TokenStore.
+func (s *MemoryTokenStore) Delete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = nil
+	return nil
+}
@@ -0,0 +1,140 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempTokenFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, ".tokens")
+}
+
+func TestTokenStores(t *testing.T) {
+	tokens := []byte(`{"UserRefreshToken":"refresh-token"}`)
+	stores := map[string]TokenStore{
+		"File":      NewFileTokenStore(tempTokenFile(t)),
+		"Encrypted": NewEncryptedFileTokenStore(tempTokenFile(t), []byte("passphrase")),
+		"Memory":    NewMemoryTokenStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Load(); !errors.Is(err, ErrNoTokens) {
+				t.Errorf("Expected ErrNoTokens from an empty store but got %v", err)
+			}
+			if err := store.Save(tokens); err != nil {
+				t.Fatalf("Save returned %v", err)
+			}
+			if data, err := store.Load(); err != nil || !bytes.Equal(data, tokens) {
+				t.Errorf("Load returned %q, %v", data, err)
+			}
+			if err := store.Delete(); err != nil {
+				t.Fatalf("Delete returned %v", err)
+			}
+			if _, err := store.Load(); !errors.Is(err, ErrNoTokens) {
+				t.Errorf("Expected ErrNoTokens after Delete but got %v", err)
+			}
+			if err := store.Delete(); err != nil {
+				t.Errorf("Deleting an empty store returned %v", err)
+			}
+		})
+	}
+}
+
+func TestFileTokenStorePermissions(t *testing.T) {
+	path := tempTokenFile(t)
+	// Older versions created the file readable by everyone.
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFileTokenStore(path).Save([]byte("{}")); err != nil {
+		t.Fatalf("Save returned %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected permissions 0600 but got %o", perm)
+	}
+}
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	tokens := []byte(`{"UserRefreshToken":"refresh-token"}`)
+	path := tempTokenFile(t)
+
+	t.Run("Reads plain text tokens", func(t *testing.T) {
+		if err := NewFileTokenStore(path).Save(tokens); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := NewEncryptedFileTokenStore(path, []byte("passphrase")).Load(); err != nil || !bytes.Equal(data, tokens) {
+			t.Errorf("Load returned %q, %v", data, err)
+		}
+	})
+
+	t.Run("Encrypts tokens", func(t *testing.T) {
+		if err := NewEncryptedFileTokenStore(path, []byte("passphrase")).Save(tokens); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("refresh-token")) {
+			t.Errorf("Expected the saved tokens to be encrypted but got %q", data)
+		}
+	})
+
+	t.Run("Rejects another passphrase", func(t *testing.T) {
+		if _, err := NewEncryptedFileTokenStore(path, []byte("another")).Load(); !errors.Is(err, ErrTokensUnreadable) {
+			t.Errorf("Expected ErrTokensUnreadable but got %v", err)
+		}
+	})
+
+	t.Run("Uses a key file", func(t *testing.T) {
+		key, err := LoadOrCreateKeyFile(path + ".key")
+		if err != nil || len(key) != keyFileSize {
+			t.Fatalf("LoadOrCreateKeyFile returned %d bytes, %v", len(key), err)
+		}
+		again, err := LoadOrCreateKeyFile(path + ".key")
+		if err != nil || !bytes.Equal(key, again) {
+			t.Errorf("Expected the saved key to be loaded but got %v", err)
+		}
+		store := NewEncryptedFileTokenStore(path, key)
+		if err := store.Save(tokens); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := store.Load(); err != nil || !bytes.Equal(data, tokens) {
+			t.Errorf("Load returned %q, %v", data, err)
+		}
+	})
+}
+
+// Test vectors from RFC 7914, section 11.
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		secret, salt string
+		iterations   int
+		expected     string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		key := pbkdf2SHA256([]byte(tt.secret), []byte(tt.salt), tt.iterations, 64)
+		if got := hex.EncodeToString(key); got != tt.expected {
+			t.Errorf("Derived %s from %q but expected %s", got, tt.secret, tt.expected)
+		}
+	}
+}
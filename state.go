@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/charlesyu108/spotify-cli/utils"
+)
+
+// StateFile remembers things between runs, like the volume before `mute`.
+const StateFile = "state.json"
+
+// localState is kept in the state directory of each profile.
+type localState struct {
+	MutedVolume    int  `json:",omitempty"` // Volume before `mute`, restored by `unmute`
+	SleepPID       int  `json:",omitempty"` // Process of the `sleep` timer, stopped by `sleep cancel` if it still holds the sleep lock
+	SleepCancelled bool `json:",omitempty"` // Whether `sleep cancel` is stopping the timer of SleepPID
+}
+
+// stateFile is where the local state of the profile is kept.
+func (p profile) stateFile() string {
+	return filepath.Join(p.StateDir, StateFile)
+}
+
+// loadState returns the local state of the profile. A missing or broken
+// state file is the same as an empty one, nothing in it is essential.
+func (p profile) loadState() localState {
+	var state localState
+	if err := utils.LoadJSON(p.stateFile(), &state); err != nil {
+		return localState{}
+	}
+	return state
+}
+
+// updateState lets update change the local state of the profile and saves it.
+func (p profile) updateState(update func(state *localState)) error {
+	if err := os.MkdirAll(p.StateDir, 0700); err != nil {
+		return err
+	}
+	unlock, err := utils.LockFile(p.stateFile())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state := p.loadState()
+	update(&state)
+	return utils.SaveJSON(p.stateFile(), state)
+}
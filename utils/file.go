@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Kinds of LoadError.
+var (
+	ErrFileNotFound  = errors.New("file does not exist")
+	ErrFileEmpty     = errors.New("file is empty")
+	ErrFileMalformed = errors.New("file is not valid JSON")
+)
+
+// LoadError is returned by LoadJSON when a file cannot be loaded. Use
+// errors.Is with ErrFileNotFound, ErrFileEmpty or ErrFileMalformed to tell
+// why.
+type LoadError struct {
+	File string
+	Kind error // One of ErrFileNotFound, ErrFileEmpty or ErrFileMalformed. Nil for other errors
+	Err  error // The underlying error, if any
+}
+
+// Error implements the error interface.
+func (e *LoadError) Error() string {
+	switch {
+	case e.Kind == nil:
+		return fmt.Sprintf("could not load %s: %v", e.File, e.Err)
+	case e.Err == nil:
+		return fmt.Sprintf("could not load %s: %v", e.File, e.Kind)
+	}
+	return fmt.Sprintf("could not load %s: %v: %v", e.File, e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of e.
+func (e *LoadError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// LoadJSON loads the file into the struct defined by v. Missing, empty and
+// malformed files return a *LoadError of the matching kind.
+func LoadJSON(fileName string, v interface{}) error {
+	data, err := ioutil.ReadFile(fileName)
+	switch {
+	case os.IsNotExist(err):
+		return &LoadError{File: fileName, Kind: ErrFileNotFound, Err: err}
+	case err != nil:
+		return &LoadError{File: fileName, Err: err}
+	case len(bytes.TrimSpace(data)) == 0:
+		return &LoadError{File: fileName, Kind: ErrFileEmpty}
+	}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return &LoadError{File: fileName, Kind: ErrFileMalformed, Err: err}
+	}
+	return nil
+}
+
+// SaveJSON saves the struct defined by v to the file, see WriteFileAtomic.
+func SaveJSON(fileName string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(fileName, append(data, '\n'))
+}
+
+// WriteFileAtomic replaces the file with data. The data is written to a
+// temporary file first, which is then renamed, so readers see either the old
+// or the new contents but never a partial write. The file is only readable
+// by its owner.
+//
+// Concurrent writers do not corrupt the file, but the last one wins. Hold
+// LockFile to read, modify and write it without losing updates.
+func WriteFileAtomic(fileName string, data []byte) error {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing fails once the file was renamed, which is fine.
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// LockFile takes an exclusive lock on the file, shared by every process that
+// locks it, and blocks until it gets it. The lock is held on a separate
+// "<fileName>.lock" file, so the file itself can be replaced while locked.
+// Call the returned function to release it.
+//
+// Locks are not reentrant: locking a file twice in one process deadlocks.
+func LockFile(fileName string) (unlock func(), err error) {
+	lockFile, err := os.OpenFile(fileName+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock %s: %w", fileName, err)
+	}
+	if err := lockExclusive(lockFile); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", fileName, err)
+	}
+	return func() {
+		unlockExclusive(lockFile)
+		lockFile.Close()
+	}, nil
+}
+
+// TryLockFile is LockFile without waiting: if another process holds the
+// lock, it returns ok false right away.
+func TryLockFile(fileName string) (unlock func(), ok bool, err error) {
+	lockFile, err := os.OpenFile(fileName+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not lock %s: %w", fileName, err)
+	}
+	if ok, err = tryLockExclusive(lockFile); err != nil || !ok {
+		lockFile.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("could not lock %s: %w", fileName, err)
+		}
+		return nil, false, nil
+	}
+	return func() {
+		unlockExclusive(lockFile)
+		lockFile.Close()
+	}, true, nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func TestLoadJSON(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		name     string
+		contents string
+		kind     error
+	}{
+		{"Valid", `{"Name": "test"}`, nil},
+		{"Missing", "", ErrFileNotFound},
+		{"Empty", "\n", ErrFileEmpty},
+		{"Malformed", `{"Name": `, ErrFileMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name+".json")
+			if tt.kind != ErrFileNotFound {
+				if err := ioutil.WriteFile(file, []byte(tt.contents), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var v struct{ Name string }
+			err := LoadJSON(file, &v)
+			if tt.kind == nil {
+				if err != nil || v.Name != "test" {
+					t.Errorf("LoadJSON returned %v and loaded %+v", err, v)
+				}
+				return
+			}
+			var loadErr *LoadError
+			if !errors.Is(err, tt.kind) || !errors.As(err, &loadErr) || loadErr.File != file {
+				t.Errorf("Expected *LoadError of kind %v but got %v", tt.kind, err)
+			}
+			if _, statErr := os.Stat(file); tt.kind == ErrFileNotFound && !os.IsNotExist(statErr) {
+				t.Errorf("Expected a missing file not to be created")
+			}
+		})
+	}
+}
+
+func TestSaveJSON(t *testing.T) {
+	dir := tempDir(t)
+	file := filepath.Join(dir, "config.json")
+	// Files saved by older versions were readable by everyone.
+	if err := ioutil.WriteFile(file, []byte(`{"Name": "a much longer old name"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveJSON(file, struct{ Name string }{"new"}); err != nil {
+		t.Fatalf("SaveJSON returned %v", err)
+	}
+	var v struct{ Name string }
+	if err := LoadJSON(file, &v); err != nil || v.Name != "new" {
+		t.Errorf("LoadJSON returned %v and loaded %+v", err, v)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected permissions 0600 but got %o", perm)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left but got %d files", len(entries))
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	file := filepath.Join(tempDir(t), "config.json")
+	unlock, err := LockFile(file)
+	if err != nil {
+		t.Fatalf("LockFile returned %v", err)
+	}
+
+	var mu sync.Mutex
+	locked := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlockAgain, err := LockFile(file)
+		if err != nil {
+			t.Errorf("LockFile returned %v", err)
+			return
+		}
+		mu.Lock()
+		locked = true
+		mu.Unlock()
+		unlockAgain()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if locked {
+		t.Errorf("Expected the second lock to wait for the first one")
+	}
+	mu.Unlock()
+
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the second lock to be taken once the first one was released")
+	}
+}
+
+func TestTryLockFile(t *testing.T) {
+	file := filepath.Join(tempDir(t), "sleep")
+	unlock, ok, err := TryLockFile(file)
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile returned %v, %v", ok, err)
+	}
+
+	// Locks are held by open files, so a second one conflicts even in the
+	// same process.
+	if _, ok, err := TryLockFile(file); err != nil || ok {
+		t.Errorf("Expected the second lock to fail right away but got %v, %v", ok, err)
+	}
+
+	unlock()
+	unlock, ok, err = TryLockFile(file)
+	if err != nil || !ok {
+		t.Fatalf("Expected the lock to be free again but got %v, %v", ok, err)
+	}
+	unlock()
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -57,35 +56,16 @@ func OpenInBrowser(url string) error {
 	return err
 }
 
-// LoadJSON loads the file into the struct defined by v.
-func LoadJSON(fileName string, v interface{}) error {
-	file, err := os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
-	}
-
-	return json.NewDecoder(file).Decode(v)
-}
-
-// SaveJSON saves the struct defined by v to the file
-func SaveJSON(fileName string, v interface{}) error {
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
-	}
-	file.Truncate(0)
-	file.Seek(0, 0)
-	return json.NewEncoder(file).Encode(v)
-}
-
 // MakeHTTPRequest wraps http.NewRequest and client.Do to perform a request
-func MakeHTTPRequest(method string, URL string, headers map[string]string, body string) (*http.Response, error) {
-	client := new(http.Client)
+func MakeHTTPRequest(client *http.Client, method string, URL string, headers map[string]string, body string) (*http.Response, error) {
 	var reader io.Reader
 	if body != "" {
 		reader = strings.NewReader(body)
 	}
-	req, _ := http.NewRequest(method, URL, reader)
+	req, err := http.NewRequest(method, URL, reader)
+	if err != nil {
+		return nil, err
+	}
 
 	for k, v := range headers {
 		req.Header.Set(k, v)
@@ -93,13 +73,60 @@ func MakeHTTPRequest(method string, URL string, headers map[string]string, body
 	return client.Do(req)
 }
 
-// GetHomeDir gets the Current User's home directory.
+// GetHomeDir gets the Current User's home directory, preferring $HOME.
 func GetHomeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
 	usr, _ := user.Current()
 	return usr.HomeDir
 }
 
-// GetProgFilesDir gets the spotify-cli Program Files directory
+// GetProgFilesDir gets the spotify-cli Program Files directory, which is
+// $SPOTIFY_CLI_HOME if set and ~/.spotify-cli otherwise. Where the XDG Base
+// Directory Specification applies, it only holds files of older versions.
 func GetProgFilesDir() string {
+	if dir := os.Getenv("SPOTIFY_CLI_HOME"); dir != "" {
+		return dir
+	}
 	return filepath.Join(GetHomeDir(), ".spotify-cli")
 }
+
+// UsesXDG reports whether files are kept in the directories of the XDG Base
+// Directory Specification, as is the convention on Linux and the BSDs,
+// rather than all in GetProgFilesDir. Setting $SPOTIFY_CLI_HOME opts out.
+func UsesXDG() bool {
+	return os.Getenv("SPOTIFY_CLI_HOME") == "" && runtime.GOOS != "windows" && runtime.GOOS != "darwin"
+}
+
+// GetConfigDir gets the directory of the config files,
+// $XDG_CONFIG_HOME/spotify-cli by default.
+func GetConfigDir() string {
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// GetStateDir gets the directory of the tokens and other state kept between
+// runs, $XDG_STATE_HOME/spotify-cli by default.
+func GetStateDir() string {
+	return xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+}
+
+// xdgDir returns the spotify-cli directory in the base directory named by
+// env, which defaults to fallback in the home directory.
+func xdgDir(env string, fallback string) string {
+	if !UsesXDG() {
+		return GetProgFilesDir()
+	}
+	base := os.Getenv(env)
+	// The specification says relative paths are invalid and must be ignored.
+	if !filepath.IsAbs(base) {
+		base = filepath.Join(GetHomeDir(), fallback)
+	}
+	return filepath.Join(base, "spotify-cli")
+}
+
+// IsTerminal reports whether f is an interactive terminal, i.e. not a pipe or a file.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestDirs(t *testing.T) {
+	home := tempDir(t)
+	setenv(t, "HOME", home)
+
+	t.Run("SPOTIFY_CLI_HOME", func(t *testing.T) {
+		setenv(t, "SPOTIFY_CLI_HOME", filepath.Join(home, "cli"))
+		for _, dir := range []string{GetProgFilesDir(), GetConfigDir(), GetStateDir()} {
+			if dir != filepath.Join(home, "cli") {
+				t.Errorf("Expected every directory to be $SPOTIFY_CLI_HOME but got %s", dir)
+			}
+		}
+	})
+
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG directories are not used on " + runtime.GOOS)
+	}
+	setenv(t, "SPOTIFY_CLI_HOME", "")
+
+	t.Run("Defaults", func(t *testing.T) {
+		setenv(t, "XDG_CONFIG_HOME", "")
+		setenv(t, "XDG_STATE_HOME", "relative/paths/are/ignored")
+		expected := map[string]string{
+			GetConfigDir(): filepath.Join(home, ".config", "spotify-cli"),
+			GetStateDir():  filepath.Join(home, ".local", "state", "spotify-cli"),
+		}
+		for got, want := range expected {
+			if got != want {
+				t.Errorf("Expected %s but got %s", want, got)
+			}
+		}
+	})
+
+	t.Run("XDG variables", func(t *testing.T) {
+		setenv(t, "XDG_CONFIG_HOME", "/xdg/config")
+		setenv(t, "XDG_STATE_HOME", "/xdg/state")
+		expected := map[string]string{
+			GetConfigDir(): filepath.Join("/xdg/config", "spotify-cli"),
+			GetStateDir():  filepath.Join("/xdg/state", "spotify-cli"),
+		}
+		for got, want := range expected {
+			if got != want {
+				t.Errorf("Expected %s but got %s", want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charlesyu108/spotify-cli/spotify"
+	"github.com/urfave/cli/v2"
+)
+
+// volumeChange is a volume to set, as given to `volume`.
+type volumeChange struct {
+	percent  int // Volume, or the change of it if relative
+	relative bool
+}
+
+// parseVolumeChange parses volumes like '40', '40%', '+10', '-5', 'up' and
+// 'down'. up and down change the volume by step.
+func parseVolumeChange(arg string, step int) (volumeChange, error) {
+	switch arg {
+	case "up":
+		return volumeChange{percent: step, relative: true}, nil
+	case "down":
+		return volumeChange{percent: -step, relative: true}, nil
+	}
+
+	var change volumeChange
+	sign := 1
+	number := strings.TrimSuffix(arg, "%")
+	switch {
+	case strings.HasPrefix(number, "+"):
+		change.relative, number = true, number[1:]
+	case strings.HasPrefix(number, "-"):
+		change.relative, number, sign = true, number[1:], -1
+	}
+	// Atoi would accept another sign, i.e. '+-5'.
+	percent, err := strconv.Atoi(number)
+	if err != nil || number == "" || number[0] < '0' || number[0] > '9' || percent > 100 {
+		return change, fmt.Errorf("'%s' is not a volume between 0 and 100, a change like '+10' or '-5', 'up' or 'down'", arg)
+	}
+	change.percent = sign * percent
+	return change, nil
+}
+
+// apply returns the volume after the change from current, clamped to 0-100.
+func (change volumeChange) apply(current int) int {
+	percent := change.percent
+	if change.relative {
+		percent += current
+	}
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
+
+// volumeStep is the configured VolumeStep, or its default.
+func volumeStep(Spotify *spotify.Spotify) int {
+	if step := Spotify.Config.VolumeStep; step > 0 {
+		return step
+	}
+	return spotify.DefaultVolumeStep
+}
+
+// showVolume prints the volume of the active device once Spotify has caught
+// up with a change.
+func showVolume(Spotify *spotify.Spotify) error {
+	time.Sleep(200 * time.Millisecond)
+	device, err := Spotify.ActiveDevice()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Volume: %d%% on %s\n", device.VolumePercent, device.Name)
+	return nil
+}
+
+func handleVolume(c *cli.Context) error {
+	if helpAsked(c) {
+		return cli.ShowCommandHelp(c, c.Command.Name)
+	}
+	volArg := c.Args().Get(0)
+	if volArg == "fade" {
+		return handleVolumeFade(c)
+	}
+	if volArg == "" {
+		return usageError("Positional argument `volume-percent` not provided.")
+	}
+	if c.NArg() > 1 {
+		return usageError("Only one positional argument `volume-percent` is allowed.")
+	}
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	change, err := parseVolumeChange(volArg, volumeStep(Spotify))
+	if err != nil {
+		return usageError("Positional argument `volume-percent` is invalid: %v.", err)
+	}
+
+	var current int
+	if change.relative {
+		device, err := Spotify.ActiveDevice()
+		if err != nil {
+			return err
+		}
+		current = device.VolumePercent
+	}
+	if err := Spotify.Volume(change.apply(current)); err != nil {
+		return err
+	}
+	return showVolume(Spotify)
+}
+
+func handleMute(c *cli.Context) error {
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	device, err := Spotify.ActiveDevice()
+	if err != nil {
+		return err
+	}
+	if device.VolumePercent == 0 {
+		fmt.Printf("Already muted.\n")
+		return nil
+	}
+
+	// Remember the volume first, so that it is not lost if muting succeeds
+	// but saving fails.
+	if err := p.updateState(func(state *localState) { state.MutedVolume = device.VolumePercent }); err != nil {
+		return err
+	}
+	if err := Spotify.Volume(0); err != nil {
+		return err
+	}
+	fmt.Printf("Muted, `unmute` restores the volume to %d%%.\n", device.VolumePercent)
+	return nil
+}
+
+func handleUnmute(c *cli.Context) error {
+	Spotify, err := newSpotify(c)
+	if err != nil {
+		return err
+	}
+	p, err := selectedProfile(c)
+	if err != nil {
+		return err
+	}
+	device, err := Spotify.ActiveDevice()
+	if err != nil {
+		return err
+	}
+	if device.VolumePercent > 0 {
+		fmt.Printf("Not muted, the volume is %d%%.\n", device.VolumePercent)
+		return nil
+	}
+
+	percent := p.loadState().MutedVolume
+	if percent == 0 {
+		return cli.Exit("There is no volume to restore, set one with `volume <volume-percent>`.", exitFailure)
+	}
+	if err := Spotify.Volume(percent); err != nil {
+		return err
+	}
+	if err := p.updateState(func(state *localState) { state.MutedVolume = 0 }); err != nil {
+		return err
+	}
+	return showVolume(Spotify)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVolumeChange(t *testing.T) {
+	const step = 10
+	tests := []struct {
+		arg      string
+		current  int
+		expected int
+	}{
+		{"40", 70, 40},
+		{"40%", 70, 40},
+		{"0", 70, 0},
+		{"100", 70, 100},
+		{"+10", 70, 80},
+		{"+10%", 70, 80},
+		{"-5", 70, 65},
+		{"+50", 70, 100},
+		{"-100", 70, 0},
+		{"up", 95, 100},
+		{"down", 70, 60},
+		{"down", 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			change, err := parseVolumeChange(tt.arg, step)
+			if err != nil {
+				t.Fatalf("parseVolumeChange returned %v", err)
+			}
+			if percent := change.apply(tt.current); percent != tt.expected {
+				t.Errorf("Expected %d%% but got %d%%", tt.expected, percent)
+			}
+		})
+	}
+
+	for _, arg := range []string{"", "loud", "101", "+101", "-", "+", "+-5", "--5", "4O", "40.5", "%", " 40", "UP"} {
+		if _, err := parseVolumeChange(arg, step); err == nil {
+			t.Errorf("Expected '%s' to be invalid", arg)
+		}
+	}
+}
+
+func TestLocalState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spotify-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := profile{Name: DefaultProfile, ConfigDir: dir, StateDir: dir}
+
+	if state := p.loadState(); state != (localState{}) {
+		t.Errorf("Expected an empty state but got %+v", state)
+	}
+	if err := p.updateState(func(state *localState) { state.MutedVolume = 40 }); err != nil {
+		t.Fatalf("updateState returned %v", err)
+	}
+	if state := p.loadState(); state.MutedVolume != 40 {
+		t.Errorf("Expected the muted volume to be saved but got %+v", state)
+	}
+
+	if err := ioutil.WriteFile(p.stateFile(), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if state := p.loadState(); state != (localState{}) {
+		t.Errorf("Expected a broken state file to be ignored but got %+v", state)
+	}
+}